@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose time only passes when advanced, for tests.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+	added  chan struct{} // receives whenever a timer is made
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		timers: map[*fakeTimer]struct{}{},
+		added:  make(chan struct{}, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+	} else {
+		c.timers[t] = struct{}{}
+	}
+	c.added <- struct{}{}
+	return t
+}
+
+// Advance moves the time on by d, firing the timers that come due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.at.After(c.now) {
+			t.c <- c.now
+			delete(c.timers, t)
+		}
+	}
+}
+
+// WaitTimer blocks until a timer has been made since the last call.
+func (c *fakeClock) WaitTimer(t *testing.T) {
+	select {
+	case <-c.added:
+	case <-time.After(time.Second):
+		t.Fatal("no timer made")
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, ok := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return ok
+}
+
+func TestSystemClock(t *testing.T) {
+
+	before := time.Now()
+	now := SystemClock.Now()
+	assert.False(t, now.Before(before))
+
+	timer := SystemClock.NewTimer(time.Millisecond)
+	select {
+	case fired := <-timer.C():
+		assert.False(t, fired.Before(now))
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+	assert.False(t, timer.Stop())
+
+	timer = SystemClock.NewTimer(time.Hour)
+	assert.True(t, timer.Stop())
+}
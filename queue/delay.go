@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// delayed is an element of a DelayQueue, due at a given time. Elements due at
+// the same time are popped in the order they were pushed.
+type delayed[T any] struct {
+	elem T
+	at   time.Time
+	seq  uint64
+}
+
+func delayedLess[T any](a, b delayed[T]) bool {
+	if a.at.Equal(b.at) {
+		return a.seq < b.seq
+	}
+	return a.at.Before(b.at)
+}
+
+// DelayQueue is a queue of unbounded capacity in which every element is due
+// at a given time, and cannot be popped before then. Elements are popped in
+// the order they are due. A DelayQueue is safe for concurrent use.
+//
+// Like a Queue, a DelayQueue can be closed. Elements already in the queue are
+// still popped when they are due after it is closed.
+type DelayQueue[T any] struct {
+	clock Clock
+
+	mu      sync.Mutex
+	items   *PriorityQueue[delayed[T]]
+	seq     uint64
+	changed chan struct{} // closed and replaced whenever the queue changes
+	closed  bool
+}
+
+// NewDelay returns an empty delay queue that tells the time with clock. If
+// clock is nil, the queue uses SystemClock.
+func NewDelay[T any](clock Clock) *DelayQueue[T] {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &DelayQueue[T]{
+		clock:   clock,
+		items:   NewPriority(delayedLess[T]),
+		changed: make(chan struct{}),
+	}
+}
+
+// Close closes the queue. Pushes after Close returns ErrClosed, and pops
+// blocked on an empty queue return. Closing a closed queue has no effect.
+func (q *DelayQueue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// PushAt adds an element to the queue, due at time at. It returns ErrClosed if
+// the queue is closed.
+func (q *DelayQueue[T]) PushAt(elem T, at time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	q.items.Push(delayed[T]{elem: elem, at: at, seq: q.seq})
+	q.seq++
+	q.notify()
+	return nil
+}
+
+// PushAfter adds an element to the queue, due once duration d has passed. It
+// returns ErrClosed if the queue is closed.
+func (q *DelayQueue[T]) PushAfter(elem T, d time.Duration) error {
+	return q.PushAt(elem, q.clock.Now().Add(d))
+}
+
+// Pop removes the earliest element of the queue, blocking until it is due. It
+// returns false if the queue is closed and empty.
+func (q *DelayQueue[T]) Pop() (T, bool) {
+	elem, err := q.PopContext(context.Background())
+	return elem, err == nil
+}
+
+// TryPop removes the earliest element of the queue if it is due, and reports
+// whether it did.
+func (q *DelayQueue[T]) TryPop() (elem T, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	next, ok := q.items.Peek()
+	if !ok || next.at.After(q.clock.Now()) {
+		return elem, false
+	}
+	q.items.Pop()
+	return next.elem, true
+}
+
+// PopContext removes the earliest element of the queue, blocking until it is
+// due or until ctx is done, in which case it returns the error of ctx. It
+// returns ErrClosed if the queue is closed and empty.
+func (q *DelayQueue[T]) PopContext(ctx context.Context) (elem T, err error) {
+	for {
+		q.mu.Lock()
+		next, ok := q.items.Peek()
+		var wait time.Duration
+		if ok {
+			if wait = next.at.Sub(q.clock.Now()); wait <= 0 {
+				q.items.Pop()
+				q.mu.Unlock()
+				return next.elem, nil
+			}
+		} else if q.closed {
+			q.mu.Unlock()
+			return elem, ErrClosed
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		// wait until the earliest element is due, or an earlier one is pushed
+		var due <-chan time.Time
+		var timer Timer
+		if ok {
+			timer = q.clock.NewTimer(wait)
+			due = timer.C()
+		}
+		select {
+		case <-due:
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return elem, err
+		}
+	}
+}
+
+// Drain removes and returns all elements in the queue, in the order they are
+// due, without waiting for them to be due.
+func (q *DelayQueue[T]) Drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	elems := make([]T, 0, q.items.Len())
+	for {
+		next, ok := q.items.Pop()
+		if !ok {
+			return elems
+		}
+		elems = append(elems, next.elem)
+	}
+}
+
+// Len returns the number of elements in the queue, whether due or not.
+func (q *DelayQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items.Len()
+}
+
+// notify wakes the pops waiting on the queue. It must be called with q.mu held.
+func (q *DelayQueue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
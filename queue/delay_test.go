@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayQueueOrder(t *testing.T) {
+
+	type push struct {
+		elem  string
+		after time.Duration
+	}
+
+	var tests = map[string]struct {
+		pushes  []push
+		advance time.Duration
+		exp     []string
+	}{
+		"nothing due": {
+			pushes:  []push{{"a", time.Second}, {"b", 2 * time.Second}},
+			advance: 0,
+			exp:     []string{},
+		},
+		"some due": {
+			pushes:  []push{{"a", 3 * time.Second}, {"b", time.Second}, {"c", 2 * time.Second}},
+			advance: 2 * time.Second,
+			exp:     []string{"b", "c"},
+		},
+		"all due": {
+			pushes:  []push{{"a", 3 * time.Second}, {"b", time.Second}, {"c", 2 * time.Second}},
+			advance: time.Minute,
+			exp:     []string{"b", "c", "a"},
+		},
+		"same time in push order": {
+			pushes:  []push{{"a", time.Second}, {"b", time.Second}, {"c", 0}, {"d", time.Second}},
+			advance: time.Second,
+			exp:     []string{"c", "a", "b", "d"},
+		},
+		"already due": {
+			pushes:  []push{{"a", -time.Second}, {"b", 0}},
+			advance: 0,
+			exp:     []string{"a", "b"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			q := NewDelay[string](clock)
+			for _, p := range tt.pushes {
+				require.NoError(t, q.PushAfter(p.elem, p.after))
+			}
+			clock.Advance(tt.advance)
+
+			popped := []string{}
+			for {
+				elem, ok := q.TryPop()
+				if !ok {
+					break
+				}
+				popped = append(popped, elem)
+			}
+			assert.Equal(t, tt.exp, popped)
+			assert.Equal(t, len(tt.pushes)-len(tt.exp), q.Len())
+		})
+	}
+}
+
+func TestDelayQueuePopWaits(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewDelay[int](clock)
+	start := clock.Now()
+	q.PushAt(1, start.Add(10*time.Second))
+
+	popped := make(chan int)
+	go func() {
+		elem, _ := q.Pop()
+		popped <- elem
+	}()
+
+	// the pop waits for the element due at 10s
+	clock.WaitTimer(t)
+	clock.Advance(5 * time.Second)
+	select {
+	case <-popped:
+		t.Fatal("popped before due")
+	default:
+	}
+
+	// an earlier element wakes the pop to wait for it instead
+	q.PushAfter(2, time.Second)
+	clock.WaitTimer(t)
+	clock.Advance(time.Second)
+	assert.Equal(t, 2, <-popped)
+
+	go func() {
+		elem, _ := q.Pop()
+		popped <- elem
+	}()
+	clock.WaitTimer(t)
+	clock.Advance(4 * time.Second)
+	assert.Equal(t, 1, <-popped)
+	assert.Equal(t, start.Add(10*time.Second), clock.Now())
+}
+
+func TestDelayQueueEmptyPopWaits(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewDelay[int](clock)
+
+	// several pops wait on an empty queue, and each gets an element
+	const n = 5
+	var wg sync.WaitGroup
+	popped := make(chan int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			elem, ok := q.Pop()
+			assert.True(t, ok)
+			popped <- elem
+		}()
+	}
+	for i := 0; i < n; i++ {
+		q.PushAfter(i, 0)
+	}
+	wg.Wait()
+	close(popped)
+
+	sum := 0
+	for elem := range popped {
+		sum += elem
+	}
+	assert.Equal(t, 0+1+2+3+4, sum)
+}
+
+func TestDelayQueueClose(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewDelay[int](clock)
+	q.PushAfter(1, time.Second)
+	q.PushAfter(2, time.Hour)
+	q.Close()
+	q.Close()
+
+	assert.ErrorIs(t, q.PushAfter(3, 0), ErrClosed)
+
+	// elements pushed before Close are still popped when due
+	clock.Advance(time.Second)
+	elem, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, elem)
+
+	assert.Equal(t, []int{2}, q.Drain())
+	_, ok = q.Pop()
+	assert.False(t, ok)
+
+	// a pop blocked on an empty queue returns when it is closed
+	q = NewDelay[int](clock)
+	done := make(chan error)
+	go func() {
+		_, err := q.PopContext(context.Background())
+		done <- err
+	}()
+	q.Close()
+	assert.ErrorIs(t, <-done, ErrClosed)
+}
+
+func TestDelayQueuePopContext(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewDelay[int](clock)
+	q.PushAfter(1, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := q.PopContext(ctx)
+		done <- err
+	}()
+	clock.WaitTimer(t)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// the pending timer was stopped and the element is still queued
+	assert.Empty(t, clock.timers)
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestDelayQueueSystemClock(t *testing.T) {
+
+	q := NewDelay[int](nil)
+	start := time.Now()
+	q.PushAfter(1, 20*time.Millisecond)
+	elem, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, elem)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
@@ -0,0 +1,119 @@
+package queue
+
+// minDequeCap is the capacity of the buffer of a deque when it first grows,
+// and below which it never shrinks.
+const minDequeCap = 16
+
+// Deque is a double ended queue of unbounded capacity, stored in a ring buffer
+// that grows and shrinks by doubling and halving. The zero value is an empty
+// deque ready to use.
+//
+// Unlike Queue, a Deque is not safe for concurrent use.
+type Deque[T any] struct {
+	buf   []T // len(buf) is zero or a power of two
+	head  int
+	count int
+}
+
+// Len returns the number of elements in the deque.
+func (d *Deque[T]) Len() int {
+	return d.count
+}
+
+// PushBack adds an element to the back of the deque.
+func (d *Deque[T]) PushBack(elem T) {
+	d.grow()
+	d.buf[d.index(d.count)] = elem
+	d.count++
+}
+
+// PushFront adds an element to the front of the deque.
+func (d *Deque[T]) PushFront(elem T) {
+	d.grow()
+	d.head = d.index(len(d.buf) - 1)
+	d.buf[d.head] = elem
+	d.count++
+}
+
+// PopFront removes and returns the element at the front of the deque. It
+// returns false if the deque is empty.
+func (d *Deque[T]) PopFront() (elem T, ok bool) {
+	if d.count == 0 {
+		return elem, false
+	}
+	var zero T
+	elem, d.buf[d.head] = d.buf[d.head], zero
+	d.head = d.index(1)
+	d.count--
+	d.shrink()
+	return elem, true
+}
+
+// PopBack removes and returns the element at the back of the deque. It
+// returns false if the deque is empty.
+func (d *Deque[T]) PopBack() (elem T, ok bool) {
+	if d.count == 0 {
+		return elem, false
+	}
+	var zero T
+	i := d.index(d.count - 1)
+	elem, d.buf[i] = d.buf[i], zero
+	d.count--
+	d.shrink()
+	return elem, true
+}
+
+// PeekFront returns the element at the front of the deque without removing
+// it. It returns false if the deque is empty.
+func (d *Deque[T]) PeekFront() (elem T, ok bool) {
+	if d.count == 0 {
+		return elem, false
+	}
+	return d.buf[d.head], true
+}
+
+// PeekBack returns the element at the back of the deque without removing it.
+// It returns false if the deque is empty.
+func (d *Deque[T]) PeekBack() (elem T, ok bool) {
+	if d.count == 0 {
+		return elem, false
+	}
+	return d.buf[d.index(d.count-1)], true
+}
+
+// index returns the position in the buffer of the i-th element from the
+// front.
+func (d *Deque[T]) index(i int) int {
+	return (d.head + i) & (len(d.buf) - 1)
+}
+
+// grow doubles the buffer if it is full.
+func (d *Deque[T]) grow() {
+	if d.count < len(d.buf) {
+		return
+	}
+	size := len(d.buf) * 2
+	if size == 0 {
+		size = minDequeCap
+	}
+	d.resize(size)
+}
+
+// shrink halves the buffer if it is at most a quarter full.
+func (d *Deque[T]) shrink() {
+	if len(d.buf) > minDequeCap && d.count <= len(d.buf)/4 {
+		d.resize(len(d.buf) / 2)
+	}
+}
+
+func (d *Deque[T]) resize(size int) {
+	buf := make([]T, size)
+	if d.head+d.count <= len(d.buf) {
+		copy(buf, d.buf[d.head:d.head+d.count])
+	} else {
+		n := copy(buf, d.buf[d.head:])
+		copy(buf[n:], d.buf[:d.count-n])
+	}
+	d.buf = buf
+	d.head = 0
+}
@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"container/list"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeque(t *testing.T) {
+
+	var tests = map[string]struct {
+		ops      func(*Deque[int])
+		expFront []int
+	}{
+		"empty": {
+			ops:      func(d *Deque[int]) {},
+			expFront: []int{},
+		},
+		"push back": {
+			ops: func(d *Deque[int]) {
+				d.PushBack(1)
+				d.PushBack(2)
+				d.PushBack(3)
+			},
+			expFront: []int{1, 2, 3},
+		},
+		"push front": {
+			ops: func(d *Deque[int]) {
+				d.PushFront(1)
+				d.PushFront(2)
+				d.PushFront(3)
+			},
+			expFront: []int{3, 2, 1},
+		},
+		"mixed": {
+			ops: func(d *Deque[int]) {
+				d.PushBack(2)
+				d.PushFront(1)
+				d.PushBack(3)
+				d.PopBack()
+				d.PushBack(4)
+			},
+			expFront: []int{1, 2, 4},
+		},
+		"wrap around while growing": {
+			ops: func(d *Deque[int]) {
+				for i := 0; i < 10; i++ {
+					d.PushBack(i)
+				}
+				for i := 0; i < 8; i++ {
+					d.PopFront()
+				}
+				for i := 10; i < 40; i++ {
+					d.PushBack(i)
+				}
+				for i := 0; i < 28; i++ {
+					d.PopFront()
+				}
+			},
+			expFront: []int{36, 37, 38, 39},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var d Deque[int]
+			tt.ops(&d)
+			assert.Equal(t, len(tt.expFront), d.Len())
+
+			if len(tt.expFront) > 0 {
+				front, ok := d.PeekFront()
+				assert.True(t, ok)
+				assert.Equal(t, tt.expFront[0], front)
+				back, ok := d.PeekBack()
+				assert.True(t, ok)
+				assert.Equal(t, tt.expFront[len(tt.expFront)-1], back)
+			}
+
+			got := []int{}
+			for {
+				e, ok := d.PopFront()
+				if !ok {
+					break
+				}
+				got = append(got, e)
+			}
+			assert.Equal(t, tt.expFront, got)
+
+			_, ok := d.PopBack()
+			assert.False(t, ok)
+			_, ok = d.PeekFront()
+			assert.False(t, ok)
+			_, ok = d.PeekBack()
+			assert.False(t, ok)
+		})
+	}
+}
+
+// TestDequeRandom checks a deque against a slice through random operations.
+func TestDequeRandom(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+	var d Deque[int]
+	model := []int{}
+
+	for i := 0; i < 20000; i++ {
+		switch op := rng.Intn(10); {
+		case op < 3:
+			d.PushBack(i)
+			model = append(model, i)
+		case op < 6:
+			d.PushFront(i)
+			model = append([]int{i}, model...)
+		case op < 8:
+			e, ok := d.PopFront()
+			assert.Equal(t, len(model) > 0, ok)
+			if ok {
+				assert.Equal(t, model[0], e)
+				model = model[1:]
+			}
+		default:
+			e, ok := d.PopBack()
+			assert.Equal(t, len(model) > 0, ok)
+			if ok {
+				assert.Equal(t, model[len(model)-1], e)
+				model = model[:len(model)-1]
+			}
+		}
+		if d.Len() != len(model) {
+			t.Fatalf("length %d after %d operations, expected %d", d.Len(), i, len(model))
+		}
+	}
+}
+
+func TestDequeShrinks(t *testing.T) {
+
+	var d Deque[int]
+	for i := 0; i < 1000; i++ {
+		d.PushBack(i)
+	}
+	for i := 0; i < 995; i++ {
+		d.PopFront()
+	}
+	assert.Equal(t, minDequeCap, len(d.buf))
+
+	e, _ := d.PeekFront()
+	assert.Equal(t, 995, e)
+}
+
+// BenchmarkDeque and BenchmarkList push and pop the same elements through a
+// Deque and through a container/list, the usual unbounded queue of the
+// standard library, which allocates for every element.
+func BenchmarkDeque(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var d Deque[int]
+		for j := 0; j < 1000; j++ {
+			d.PushBack(j)
+			d.PushBack(j)
+			d.PopFront()
+		}
+		for d.Len() > 0 {
+			d.PopFront()
+		}
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := list.New()
+		for j := 0; j < 1000; j++ {
+			l.PushBack(j)
+			l.PushBack(j)
+			l.Remove(l.Front())
+		}
+		for l.Len() > 0 {
+			l.Remove(l.Front())
+		}
+	}
+}
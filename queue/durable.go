@@ -0,0 +1,647 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrCorrupt indicates that the files of a durable queue are damaged in a way
+// that recovery cannot repair.
+var ErrCorrupt = errors.New("queue files are corrupt")
+
+// errTorn marks damage to the last record of a segment, as left by a crash in
+// the middle of a push.
+var errTorn = fmt.Errorf("last record is incomplete: %w", ErrCorrupt)
+
+// DefaultSegmentSize is the size of the segment files of a durable queue if
+// none is given.
+const DefaultSegmentSize = 4 << 20
+
+const (
+	segmentExt    = ".seg"
+	consumerFile  = "consumer"
+	recordHeader  = 8  // length and checksum of a record
+	consumerBytes = 20 // segment, offset and checksum of the consumer offset
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// DurableOptions configures a durable queue.
+type DurableOptions struct {
+	// SegmentSize is the size in bytes up to which a segment file is filled
+	// before the next is started. An element too large for a segment on its
+	// own is written to a segment of its own. If zero, DefaultSegmentSize is
+	// used.
+	SegmentSize int64
+	// Sync makes every push and pop wait until its change has reached stable
+	// storage, so that it survives a crash of the machine and not only of the
+	// process, at a cost in speed.
+	Sync bool
+}
+
+// segment is a segment file, named for its id.
+type segment struct {
+	id      uint64
+	size    int64
+	records int // not yet popped
+}
+
+// DurableQueue is a first-in, first-out queue of unbounded capacity kept in a
+// directory, so that its elements survive the process. Elements are encoded as
+// JSON, and appended as records to a sequence of segment files. A record is
+// the length of its data and the CRC-32C of its data, as big-endian uint32,
+// followed by the data.
+//
+// The position of the consumer is kept in a separate file, written as each
+// element is popped, and segment files are deleted once all of their elements
+// are popped. An element is therefore delivered at most once: an element
+// popped before a crash is gone after it, even if it was never processed.
+//
+// When a queue is opened, a record cut short or damaged at the end of the
+// last segment, as left by a crash in the middle of a push, is truncated.
+// Damage anywhere else, including a damaged record followed by others, is
+// reported as ErrCorrupt.
+//
+// A DurableQueue is safe for concurrent use, but a directory must only be
+// opened by one queue at a time.
+type DurableQueue[T any] struct {
+	dir  string
+	opts DurableOptions
+
+	mu      sync.Mutex
+	segs    []segment // the first is read from, the last written to
+	r, w    *os.File
+	roff    int64 // offset of the next record in the first segment
+	count   int
+	changed chan struct{} // closed and replaced whenever the queue changes
+	closed  bool
+	err     error // the first error popping an element
+}
+
+// OpenDurable opens the durable queue in directory dir, creating it if it does
+// not exist, and recovers the elements left in it.
+func OpenDurable[T any](dir string, opts DurableOptions) (*DurableQueue[T], error) {
+
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error opening durable queue: %w", err)
+	}
+
+	q := &DurableQueue[T]{
+		dir:     dir,
+		opts:    opts,
+		changed: make(chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		q.closeFiles()
+		return nil, fmt.Errorf("error opening durable queue: %w", err)
+	}
+	return q, nil
+}
+
+// recover reads the consumer offset and the segments from the directory,
+// deleting segments already consumed and truncating a damaged last record.
+func (q *DurableQueue[T]) recover() error {
+
+	ids, err := q.listSegments()
+	if err != nil {
+		return err
+	}
+
+	seg, off, found, err := q.readConsumer()
+	if err != nil {
+		return err
+	}
+	if !found && len(ids) > 0 {
+		seg = ids[0]
+	}
+
+	// a crash may leave segments that were consumed but not yet deleted
+	for len(ids) > 0 && ids[0] < seg {
+		if err := os.Remove(q.segmentPath(ids[0])); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+
+	if len(ids) == 0 {
+		if off != 0 {
+			return fmt.Errorf("consumer segment %d is missing: %w", seg, ErrCorrupt)
+		}
+		f, err := os.OpenFile(q.segmentPath(seg), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		ids = []uint64{seg}
+	}
+	if ids[0] != seg {
+		return fmt.Errorf("consumer segment %d is missing: %w", seg, ErrCorrupt)
+	}
+
+	for i, id := range ids {
+		last := i == len(ids)-1
+
+		f, err := os.OpenFile(q.segmentPath(id), os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		start := int64(0)
+		if i == 0 {
+			start = off
+		}
+		if start > info.Size() {
+			f.Close()
+			return fmt.Errorf("consumer offset %d is past the end of segment %d: %w", start, id, ErrCorrupt)
+		}
+
+		n, end, err := scanSegment(f, start, info.Size())
+		if err != nil {
+			if !last || !errors.Is(err, errTorn) {
+				f.Close()
+				return fmt.Errorf("segment %d at offset %d: %w", id, end, err)
+			}
+			if err := f.Truncate(end); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+
+		q.count += n
+		q.segs = append(q.segs, segment{id: id, size: end, records: n})
+	}
+
+	if q.r, err = os.Open(q.segmentPath(seg)); err != nil {
+		return err
+	}
+	q.roff = off
+	if q.w, err = os.OpenFile(q.segmentPath(ids[len(ids)-1]), os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		return err
+	}
+	return q.advance()
+}
+
+// scanSegment checks the records of a segment from offset start, and returns
+// their number and the offset of their end. If it finds a record that is cut
+// short or damaged, it returns the offset of the record and an error wrapping
+// ErrCorrupt, which also wraps errTorn if the record is the last.
+func scanSegment(f *os.File, start, size int64) (n int, end int64, err error) {
+	end = start
+	for end < size {
+		_, next, err := readRecord(f, end, size)
+		if err != nil {
+			return n, end, err
+		}
+		n++
+		end = next
+	}
+	return n, end, nil
+}
+
+// readRecord reads the record at offset off of a segment of the given size,
+// and checks it. A record that runs past the end of the segment, or is
+// damaged and ends where the segment does, is the torn last record of the
+// segment; the error returned for it wraps errTorn.
+//
+// It returns the offset of the end of the record, which is also returned
+// along with an error if the length of the record could be read, and is zero
+// otherwise.
+func readRecord(f *os.File, off, size int64) (data []byte, end int64, err error) {
+
+	if off+recordHeader > size {
+		return nil, 0, fmt.Errorf("record header past the end of the segment: %w", errTorn)
+	}
+	var header [recordHeader]byte
+	if _, err := f.ReadAt(header[:], off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, fmt.Errorf("record header: %v: %w", err, ErrCorrupt)
+	}
+
+	// the length is checked before anything is allocated for it, since a torn
+	// header may hold any length
+	length := int64(binary.BigEndian.Uint32(header[0:]))
+	end = off + recordHeader + length
+	if end > size {
+		return nil, 0, fmt.Errorf("record length %d past the end of the segment: %w", length, errTorn)
+	}
+
+	data = make([]byte, length)
+	if _, err := f.ReadAt(data, off+recordHeader); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, end, fmt.Errorf("record data: %v: %w", err, ErrCorrupt)
+	}
+	if crc32.Checksum(data, castagnoli) != binary.BigEndian.Uint32(header[4:]) {
+		if end == size {
+			return nil, end, fmt.Errorf("record checksum mismatch: %w", errTorn)
+		}
+		return nil, end, fmt.Errorf("record checksum mismatch: %w", ErrCorrupt)
+	}
+	return data, end, nil
+}
+
+// Close closes the queue and its files. Pushes and pops after Close return
+// ErrClosed, and pops blocked on an empty queue return. Elements left in the
+// queue stay in its directory, to be popped once it is opened again. Closing a
+// closed queue has no effect.
+func (q *DurableQueue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.notify()
+	return q.closeFiles()
+}
+
+func (q *DurableQueue[T]) closeFiles() error {
+	var err error
+	if q.w != nil {
+		if q.opts.Sync {
+			err = q.w.Sync()
+		}
+		if cerr := q.w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if q.r != nil {
+		if cerr := q.r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Push adds an element to the back of the queue. It returns ErrClosed if the
+// queue is closed, or the error of encoding or writing the element.
+func (q *DurableQueue[T]) Push(elem T) error {
+
+	data, err := json.Marshal(elem)
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, recordHeader+len(data))
+	binary.BigEndian.PutUint32(rec[0:], uint32(len(data)))
+	binary.BigEndian.PutUint32(rec[4:], crc32.Checksum(data, castagnoli))
+	copy(rec[recordHeader:], data)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+
+	if last := q.segs[len(q.segs)-1]; last.size > 0 && last.size+int64(len(rec)) > q.opts.SegmentSize {
+		if err := q.rotate(); err != nil {
+			return fmt.Errorf("error pushing to durable queue: %w", err)
+		}
+	}
+
+	last := &q.segs[len(q.segs)-1]
+	if _, err := q.w.Write(rec); err != nil {
+		// drop whatever part of the record was written
+		q.w.Truncate(last.size)
+		return fmt.Errorf("error pushing to durable queue: %w", err)
+	}
+	if q.opts.Sync {
+		if err := q.w.Sync(); err != nil {
+			return fmt.Errorf("error pushing to durable queue: %w", err)
+		}
+	}
+	last.size += int64(len(rec))
+	last.records++
+	q.count++
+	q.notify()
+	return nil
+}
+
+// TryPush adds an element to the back of the queue, and reports whether it
+// did. Since the queue is unbounded, it only fails if the queue is closed or
+// the element cannot be written.
+func (q *DurableQueue[T]) TryPush(elem T) bool {
+	return q.Push(elem) == nil
+}
+
+// PushContext adds an element to the back of the queue, unless ctx is already
+// done, in which case it returns the error of ctx. Since the queue is
+// unbounded, it never blocks.
+func (q *DurableQueue[T]) PushContext(ctx context.Context, elem T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return q.Push(elem)
+}
+
+// Pop removes the element at the front of the queue, blocking while the queue
+// is empty. Elements that cannot be read or decoded are skipped. It returns
+// false if the queue is closed, or if the files of the queue cannot be
+// written; Err reports the errors of the elements skipped and of the files.
+func (q *DurableQueue[T]) Pop() (T, bool) {
+	for {
+		elem, err := q.PopContext(context.Background())
+		if !skipped(err) {
+			return elem, err == nil
+		}
+	}
+}
+
+// TryPop removes the element at the front of the queue if it is not empty,
+// and reports whether it did. Like Pop, it skips the elements that cannot be
+// read or decoded.
+func (q *DurableQueue[T]) TryPop() (elem T, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && q.count > 0 {
+		elem, err := q.pop()
+		if !skipped(err) {
+			return elem, err == nil
+		}
+	}
+	return elem, false
+}
+
+// PopContext removes the element at the front of the queue, blocking while the
+// queue is empty until ctx is done, in which case it returns the error of ctx.
+// It returns ErrClosed if the queue is closed.
+//
+// An element that cannot be decoded, or whose record is damaged, is removed
+// from the queue all the same, and the error returned, so that it does not
+// block the elements behind it. A record damaged so that its length cannot be
+// read takes the rest of its segment with it.
+func (q *DurableQueue[T]) PopContext(ctx context.Context) (elem T, err error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return elem, ErrClosed
+		}
+		if q.count > 0 {
+			elem, err = q.pop()
+			q.mu.Unlock()
+			return elem, err
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return elem, ctx.Err()
+		}
+	}
+}
+
+// Drain removes and returns all elements in the queue, without blocking. Like
+// Pop, it skips the elements that cannot be read or decoded, and stops if the
+// files of the queue cannot be written.
+func (q *DurableQueue[T]) Drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	elems := []T{}
+	for !q.closed && q.count > 0 {
+		elem, err := q.pop()
+		if err != nil && !skipped(err) {
+			break
+		}
+		if err == nil {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// Err returns the first error that occurred popping an element, if any,
+// including those of the elements that Pop, TryPop and Drain skip.
+func (q *DurableQueue[T]) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// Len returns the number of elements in the queue.
+func (q *DurableQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// errDecode marks errors of decoding an element that was read intact.
+var errDecode = errors.New("cannot decode element")
+
+// skipped reports whether an error of pop is that of an element that was
+// removed from the queue without being returned.
+func skipped(err error) bool {
+	return errors.Is(err, errDecode) || errors.Is(err, ErrCorrupt)
+}
+
+// pop reads the element at the front of the queue and moves the consumer past
+// it, and records its error, if any. The queue must not be empty, and q.mu
+// must be held.
+func (q *DurableQueue[T]) pop() (elem T, err error) {
+	elem, err = q.next()
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	return elem, err
+}
+
+// next reads the element at the front of the queue for pop.
+func (q *DurableQueue[T]) next() (elem T, err error) {
+
+	// the segment read from may have been consumed before the next was started
+	if err := q.advance(); err != nil {
+		return elem, fmt.Errorf("error popping from durable queue: %w", err)
+	}
+
+	seg := &q.segs[0]
+	data, end, readErr := readRecord(q.r, q.roff, seg.size)
+
+	// a damaged record is skipped, or if its length is lost, along with it the
+	// rest of the segment, where the next record cannot be found
+	n := 1
+	if readErr != nil && end == 0 {
+		end, n = seg.size, seg.records
+	}
+	if err := q.writeConsumer(seg.id, end); err != nil {
+		return elem, fmt.Errorf("error popping from durable queue: %w", err)
+	}
+	if readErr != nil {
+		readErr = fmt.Errorf("error popping from durable queue: segment %d at offset %d, skipped to offset %d: %w", seg.id, q.roff, end, readErr)
+	}
+	q.roff = end
+	q.count -= n
+	seg.records -= n
+
+	var decodeErr error
+	if readErr == nil {
+		decodeErr = json.Unmarshal(data, &elem)
+	}
+	if err := q.advance(); err != nil {
+		return elem, fmt.Errorf("error popping from durable queue: %w", err)
+	}
+	if readErr != nil {
+		return elem, readErr
+	}
+	if decodeErr != nil {
+		return elem, fmt.Errorf("error popping from durable queue: %v: %w", decodeErr, errDecode)
+	}
+	return elem, nil
+}
+
+// advance moves the consumer on to the next segment while the first is fully
+// consumed, and deletes the consumed segments.
+func (q *DurableQueue[T]) advance() error {
+	for len(q.segs) > 1 && q.roff == q.segs[0].size {
+		next := q.segs[1].id
+		if err := q.writeConsumer(next, 0); err != nil {
+			return err
+		}
+		r, err := os.Open(q.segmentPath(next))
+		if err != nil {
+			return err
+		}
+		q.r.Close()
+		q.r = r
+		if err := os.Remove(q.segmentPath(q.segs[0].id)); err != nil {
+			return err
+		}
+		q.segs = q.segs[1:]
+		q.roff = 0
+	}
+	return nil
+}
+
+// rotate starts a new segment to write to.
+func (q *DurableQueue[T]) rotate() error {
+
+	id := q.segs[len(q.segs)-1].id + 1
+	w, err := os.OpenFile(q.segmentPath(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if q.opts.Sync {
+		if err := q.w.Sync(); err != nil {
+			w.Close()
+			return err
+		}
+		if err := syncDir(q.dir); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	q.w.Close()
+	q.w = w
+	q.segs = append(q.segs, segment{id: id})
+	return nil
+}
+
+// readConsumer reads the consumer offset, and reports whether there is one.
+func (q *DurableQueue[T]) readConsumer() (seg uint64, off int64, found bool, err error) {
+
+	buf, err := os.ReadFile(filepath.Join(q.dir, consumerFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if len(buf) != consumerBytes || crc32.Checksum(buf[:16], castagnoli) != binary.BigEndian.Uint32(buf[16:]) {
+		return 0, 0, false, fmt.Errorf("consumer offset: %w", ErrCorrupt)
+	}
+	return binary.BigEndian.Uint64(buf[0:]), int64(binary.BigEndian.Uint64(buf[8:])), true, nil
+}
+
+// writeConsumer replaces the consumer offset, atomically.
+func (q *DurableQueue[T]) writeConsumer(seg uint64, off int64) error {
+
+	var buf [consumerBytes]byte
+	binary.BigEndian.PutUint64(buf[0:], seg)
+	binary.BigEndian.PutUint64(buf[8:], uint64(off))
+	binary.BigEndian.PutUint32(buf[16:], crc32.Checksum(buf[:16], castagnoli))
+
+	path := filepath.Join(q.dir, consumerFile)
+	tmp, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf[:]); err != nil {
+		tmp.Close()
+		return err
+	}
+	if q.opts.Sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// listSegments returns the ids of the segment files in the directory, in
+// order.
+func (q *DurableQueue[T]) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []uint64{}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (q *DurableQueue[T]) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// notify wakes the pops waiting on the queue. It must be called with q.mu held.
+func (q *DurableQueue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,484 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type update struct {
+	ID   uint
+	Name string
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	for i, n := range names {
+		names[i] = filepath.Base(n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	segs := segments(t, dir)
+	require.NotEmpty(t, segs)
+	return filepath.Join(dir, segs[len(segs)-1])
+}
+
+func TestDurableQueue(t *testing.T) {
+
+	dir := t.TempDir()
+	q, err := OpenDurable[update](dir, DurableOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, q.Len())
+
+	_, ok := q.TryPop()
+	assert.False(t, ok)
+
+	for i := uint(1); i <= 3; i++ {
+		require.NoError(t, q.Push(update{ID: i, Name: "n"}))
+	}
+	assert.True(t, q.TryPush(update{ID: 4}))
+	assert.NoError(t, q.PushContext(context.Background(), update{ID: 5}))
+	assert.Equal(t, 5, q.Len())
+
+	elem, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, update{ID: 1, Name: "n"}, elem)
+	elem, ok = q.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, update{ID: 2, Name: "n"}, elem)
+	elem, err = q.PopContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, update{ID: 3, Name: "n"}, elem)
+
+	assert.Equal(t, []update{{ID: 4}, {ID: 5}}, q.Drain())
+	assert.Equal(t, 0, q.Len())
+
+	require.NoError(t, q.Close())
+	assert.NoError(t, q.Close())
+	assert.ErrorIs(t, q.Push(update{}), ErrClosed)
+	assert.False(t, q.TryPush(update{}))
+	_, err = q.PopContext(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+	_, ok = q.TryPop()
+	assert.False(t, ok)
+}
+
+func TestDurableQueueReopen(t *testing.T) {
+
+	var tests = map[string]struct {
+		opts   DurableOptions
+		pushes int
+		pops   int
+	}{
+		"nothing popped": {
+			pushes: 10,
+			pops:   0,
+		},
+		"some popped": {
+			pushes: 10,
+			pops:   4,
+		},
+		"all popped": {
+			pushes: 10,
+			pops:   10,
+		},
+		"across segments": {
+			opts:   DurableOptions{SegmentSize: 64},
+			pushes: 20,
+			pops:   7,
+		},
+		"synced": {
+			opts:   DurableOptions{SegmentSize: 64, Sync: true},
+			pushes: 20,
+			pops:   13,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			q, err := OpenDurable[int](dir, tt.opts)
+			require.NoError(t, err)
+			for i := 0; i < tt.pushes; i++ {
+				require.NoError(t, q.Push(i))
+			}
+			for i := 0; i < tt.pops; i++ {
+				elem, ok := q.Pop()
+				require.True(t, ok)
+				assert.Equal(t, i, elem)
+			}
+			require.NoError(t, q.Close())
+
+			q, err = OpenDurable[int](dir, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.pushes-tt.pops, q.Len())
+
+			// elements pushed after reopening follow the ones left
+			require.NoError(t, q.Push(tt.pushes))
+			exp := []int{}
+			for i := tt.pops; i <= tt.pushes; i++ {
+				exp = append(exp, i)
+			}
+			assert.Equal(t, exp, q.Drain())
+			require.NoError(t, q.Close())
+		})
+	}
+}
+
+func TestDurableQueueSegments(t *testing.T) {
+
+	dir := t.TempDir()
+	// each record of a one letter string is 11 bytes, so 3 fit in a segment
+	q, err := OpenDurable[string](dir, DurableOptions{SegmentSize: 33})
+	require.NoError(t, err)
+	defer q.Close()
+
+	for _, s := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		require.NoError(t, q.Push(s))
+	}
+	assert.Equal(t, []string{
+		"00000000000000000000.seg",
+		"00000000000000000001.seg",
+		"00000000000000000002.seg",
+	}, segments(t, dir))
+
+	// segments are deleted once fully consumed
+	for i := 0; i < 2; i++ {
+		q.Pop()
+	}
+	assert.Len(t, segments(t, dir), 3)
+	q.Pop()
+	assert.Equal(t, []string{
+		"00000000000000000001.seg",
+		"00000000000000000002.seg",
+	}, segments(t, dir))
+
+	// the last segment is kept even when consumed, to be written to
+	q.Drain()
+	assert.Equal(t, []string{"00000000000000000002.seg"}, segments(t, dir))
+
+	// an element larger than a segment gets a segment of its own
+	big := strings.Repeat("x", 50)
+	require.NoError(t, q.Push(big))
+	require.NoError(t, q.Push("h"))
+	assert.Equal(t, []string{
+		"00000000000000000002.seg",
+		"00000000000000000003.seg",
+		"00000000000000000004.seg",
+	}, segments(t, dir))
+	assert.Equal(t, []string{big, "h"}, q.Drain())
+}
+
+func TestDurableQueueRecover(t *testing.T) {
+
+	var tests = map[string]struct {
+		damage func(t *testing.T, dir string)
+		exp    []int
+		err    error
+	}{
+		"torn record header": {
+			damage: func(t *testing.T, dir string) {
+				appendFile(t, lastSegment(t, dir), []byte{0, 0})
+			},
+			exp: []int{3, 4, 5},
+		},
+		"torn record data": {
+			damage: func(t *testing.T, dir string) {
+				appendFile(t, lastSegment(t, dir), []byte{0, 0, 0, 5, 1, 2, 3, 4, '1'})
+			},
+			exp: []int{3, 4, 5},
+		},
+		"torn record length": {
+			damage: func(t *testing.T, dir string) {
+				appendFile(t, lastSegment(t, dir), []byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, '1'})
+			},
+			exp: []int{3, 4, 5},
+		},
+		"bad checksum at end": {
+			damage: func(t *testing.T, dir string) {
+				flipLastByte(t, lastSegment(t, dir))
+			},
+			exp: []int{3, 4},
+		},
+		"bad checksum before last record": {
+			damage: func(t *testing.T, dir string) {
+				path := lastSegment(t, dir)
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				data[recordHeader] ^= 0xff // the data of element 4
+				writeFile(t, path, data)
+			},
+			err: ErrCorrupt,
+		},
+		"bad checksum before end": {
+			damage: func(t *testing.T, dir string) {
+				flipLastByte(t, filepath.Join(dir, segments(t, dir)[0]))
+			},
+			err: ErrCorrupt,
+		},
+		"consumed segment not deleted": {
+			damage: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "00000000000000000000.seg"), []byte{})
+			},
+			exp: []int{3, 4, 5},
+		},
+		"consumer offset lost": {
+			damage: func(t *testing.T, dir string) {
+				require.NoError(t, os.Remove(filepath.Join(dir, consumerFile)))
+			},
+			exp: []int{2, 3, 4, 5},
+		},
+		"consumer offset damaged": {
+			damage: func(t *testing.T, dir string) {
+				flipLastByte(t, filepath.Join(dir, consumerFile))
+			},
+			err: ErrCorrupt,
+		},
+		"consumer segment missing": {
+			damage: func(t *testing.T, dir string) {
+				require.NoError(t, os.Remove(filepath.Join(dir, segments(t, dir)[0])))
+			},
+			err: ErrCorrupt,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// two elements to a segment, with elements 0 to 2 consumed
+			q, err := OpenDurable[int](dir, DurableOptions{SegmentSize: 18})
+			require.NoError(t, err)
+			for i := 0; i < 6; i++ {
+				require.NoError(t, q.Push(i))
+			}
+			for i := 0; i < 3; i++ {
+				q.Pop()
+			}
+			require.NoError(t, q.Close())
+			require.Equal(t, []string{
+				"00000000000000000001.seg",
+				"00000000000000000002.seg",
+			}, segments(t, dir))
+
+			tt.damage(t, dir)
+
+			q, err = OpenDurable[int](dir, DurableOptions{SegmentSize: 18})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			defer q.Close()
+			assert.Equal(t, len(tt.exp), q.Len())
+
+			// the queue is usable after recovery
+			require.NoError(t, q.Push(9))
+			assert.Equal(t, append(tt.exp, 9), q.Drain())
+		})
+	}
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func flipLastByte(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	writeFile(t, path, data)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestDurableQueueDecodeError(t *testing.T) {
+
+	dir := t.TempDir()
+	qs, err := OpenDurable[string](dir, DurableOptions{})
+	require.NoError(t, err)
+	qs.Push("a")
+	qs.Push("b")
+	require.NoError(t, qs.Close())
+
+	q, err := OpenDurable[int](dir, DurableOptions{})
+	require.NoError(t, err)
+	defer q.Close()
+	q.Push(3)
+
+	// undecodable elements are removed with an error, and do not block the
+	// queue
+	_, err = q.PopContext(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, []int{3}, q.Drain())
+	assert.ErrorIs(t, q.Err(), errDecode)
+	require.NoError(t, q.Close())
+
+	raw, err := OpenDurable[json.RawMessage](dir, DurableOptions{})
+	require.NoError(t, err)
+	for _, r := range []string{`"c"`, `4`, `"d"`, `5`} {
+		raw.Push(json.RawMessage(r))
+	}
+	require.NoError(t, raw.Close())
+
+	// Pop and TryPop skip them
+	q, err = OpenDurable[int](dir, DurableOptions{})
+	require.NoError(t, err)
+	defer q.Close()
+	e, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 4, e)
+	e, ok = q.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, 5, e)
+	assert.ErrorIs(t, q.Err(), errDecode)
+}
+
+func TestDurableQueueDamagedRecord(t *testing.T) {
+
+	// each element is a record of 9 bytes
+	var tests = map[string]struct {
+		damage func(data []byte)
+		exp    []int
+	}{
+		"bad checksum": {
+			damage: func(data []byte) { data[9+recordHeader] ^= 0xff },
+			exp:    []int{1, 3, 9},
+		},
+		"bad length": {
+			damage: func(data []byte) { data[9] = 0xff },
+			exp:    []int{1, 9},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			q, err := OpenDurable[int](dir, DurableOptions{})
+			require.NoError(t, err)
+			defer q.Close()
+			for i := 1; i <= 3; i++ {
+				require.NoError(t, q.Push(i))
+			}
+
+			// a record damaged after it was written does not block the queue
+			path := lastSegment(t, dir)
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			tt.damage(data)
+			writeFile(t, path, data)
+
+			e, ok := q.Pop()
+			require.True(t, ok)
+			got := []int{e}
+			_, err = q.PopContext(context.Background())
+			assert.ErrorIs(t, err, ErrCorrupt)
+			require.NoError(t, q.Push(9))
+			got = append(got, q.Drain()...)
+
+			assert.Equal(t, tt.exp, got)
+			assert.ErrorIs(t, q.Err(), ErrCorrupt)
+			assert.Zero(t, q.Len())
+		})
+	}
+}
+
+func TestDurableQueueBlocking(t *testing.T) {
+
+	q, err := OpenDurable[int](t.TempDir(), DurableOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.PopContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, q.PushContext(ctx, 1), context.DeadlineExceeded)
+
+	popped := make(chan int)
+	go func() {
+		elem, _ := q.Pop()
+		popped <- elem
+	}()
+	q.Push(7)
+	assert.Equal(t, 7, <-popped)
+
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Pop()
+		done <- ok
+	}()
+	require.NoError(t, q.Close())
+	assert.False(t, <-done)
+}
+
+func TestDurableQueueConcurrent(t *testing.T) {
+
+	const producers, consumers, perProducer = 4, 4, 100
+	dir := t.TempDir()
+	q, err := OpenDurable[int](dir, DurableOptions{SegmentSize: 256})
+	require.NoError(t, err)
+
+	var popped sync.Map
+	var wg sync.WaitGroup
+	var cwg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for {
+				elem, ok := q.Pop()
+				if !ok {
+					return
+				}
+				_, dup := popped.LoadOrStore(elem, true)
+				assert.False(t, dup)
+			}
+		}()
+	}
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				assert.NoError(t, q.Push(p*perProducer+i))
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for q.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, q.Close())
+	cwg.Wait()
+
+	n := 0
+	popped.Range(func(_, _ any) bool { n++; return true })
+	assert.Equal(t, producers*perProducer, n)
+	assert.Len(t, segments(t, dir), 1)
+}
@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLeaseExpired is returned when acknowledging an element whose lease has
+// expired, or was already acknowledged.
+var ErrLeaseExpired = errors.New("lease has expired")
+
+// DefaultVisibility is the visibility timeout of a lease queue if none is
+// given.
+const DefaultVisibility = 30 * time.Second
+
+// LeaseOptions configures a lease queue.
+type LeaseOptions[T any] struct {
+	// Visibility is how long a received element stays hidden from other
+	// receivers before it is delivered again, unless acknowledged. If zero,
+	// DefaultVisibility is used.
+	Visibility time.Duration
+	// MaxAttempts is the number of deliveries after which an element that is
+	// still not acknowledged is given up on, as a poison element that can
+	// never be processed. If zero, elements are delivered until acknowledged.
+	MaxAttempts int
+	// DeadLetter receives the elements given up on. If nil or closed, they
+	// are dropped.
+	DeadLetter *Queue[DeadLetter[T]]
+	// Clock tells the time. If nil, SystemClock is used.
+	Clock Clock
+}
+
+// Lease is a delivery of an element by a lease queue, which hides the element
+// from other receivers until the lease expires.
+type Lease[T any] struct {
+	Elem T
+	// ID identifies the element, and is the same in every delivery of it.
+	ID uint64
+	// Attempt is the number of the delivery, from 1 for the first.
+	Attempt int
+	// Deadline is the time at which the lease expires.
+	Deadline time.Time
+}
+
+// leased is an element of a lease queue.
+type leased[T any] struct {
+	elem     T
+	id       uint64
+	attempts int
+	deadline time.Time
+	handle   *Handle[*leased[T]] // in the queue of leases, while leased
+}
+
+// LeaseQueue is a first-in, first-out queue of unbounded capacity for
+// at-least-once processing. Receiving an element does not remove it from the
+// queue, but leases it for a visibility timeout, during which it is hidden
+// from other receivers. The receiver acknowledges the element with Ack once it
+// is processed, which removes it, or with Nack if it could not be processed.
+// An element whose lease expires or that is acknowledged with Nack becomes
+// visible again, at the back of the queue, to be delivered anew.
+//
+// An element delivered MaxAttempts times without being processed is sent to
+// the dead-letter queue. A LeaseQueue is safe for concurrent use.
+type LeaseQueue[T any] struct {
+	opts LeaseOptions[T]
+
+	mu       sync.Mutex
+	visible  Deque[*leased[T]]
+	leases   *PriorityQueue[*leased[T]] // by deadline
+	leasedID map[uint64]*leased[T]
+	nextID   uint64
+	changed  chan struct{} // closed and replaced whenever the queue changes
+	closed   bool
+}
+
+// NewLease returns an empty lease queue.
+func NewLease[T any](opts LeaseOptions[T]) *LeaseQueue[T] {
+	if opts.Visibility <= 0 {
+		opts.Visibility = DefaultVisibility
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	return &LeaseQueue[T]{
+		opts: opts,
+		leases: NewPriority(func(a, b *leased[T]) bool {
+			return a.deadline.Before(b.deadline)
+		}),
+		leasedID: map[uint64]*leased[T]{},
+		changed:  make(chan struct{}),
+	}
+}
+
+// Close closes the queue. Pushes after Close return ErrClosed, and receives
+// return ErrClosed once there are no elements left, visible or leased.
+// Elements can still be acknowledged after Close. Closing a closed queue has no
+// effect.
+func (q *LeaseQueue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// Push adds an element to the back of the queue. It returns ErrClosed if the
+// queue is closed.
+func (q *LeaseQueue[T]) Push(elem T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	q.nextID++
+	q.visible.PushBack(&leased[T]{elem: elem, id: q.nextID})
+	q.notify()
+	return nil
+}
+
+// Receive leases the element at the front of the queue, blocking while no
+// element is visible until ctx is done, in which case it returns the error of
+// ctx. It returns ErrClosed if the queue is closed and has no elements left.
+func (q *LeaseQueue[T]) Receive(ctx context.Context) (Lease[T], error) {
+	for {
+		q.mu.Lock()
+		now := q.opts.Clock.Now()
+		dead := q.expire(now)
+
+		if l, ok := q.visible.PopFront(); ok {
+			l.attempts++
+			l.deadline = now.Add(q.opts.Visibility)
+			l.handle = q.leases.Push(l)
+			q.leasedID[l.id] = l
+			lease := Lease[T]{Elem: l.elem, ID: l.id, Attempt: l.attempts, Deadline: l.deadline}
+			q.mu.Unlock()
+			q.bury(dead)
+			return lease, nil
+		}
+		if q.closed && q.leases.Len() == 0 {
+			q.mu.Unlock()
+			q.bury(dead)
+			return Lease[T]{}, ErrClosed
+		}
+
+		// wait until an element is pushed or returned, or the next lease expires
+		var expired <-chan time.Time
+		var timer Timer
+		if next, ok := q.leases.Peek(); ok {
+			timer = q.opts.Clock.NewTimer(next.deadline.Sub(now))
+			expired = timer.C()
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		q.bury(dead)
+
+		var err error
+		select {
+		case <-expired:
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return Lease[T]{}, err
+		}
+	}
+}
+
+// Ack acknowledges that the element of a lease was processed, and removes it
+// from the queue. It returns ErrLeaseExpired if the lease has expired, in
+// which case the element may have been delivered again.
+func (q *LeaseQueue[T]) Ack(lease Lease[T]) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l, err := q.release(lease)
+	if err != nil {
+		return err
+	}
+	delete(q.leasedID, l.id)
+	q.notify()
+	return nil
+}
+
+// Nack reports that the element of a lease could not be processed, and makes
+// it visible again at once, or sends it to the dead-letter queue if it has
+// been delivered MaxAttempts times. It returns ErrLeaseExpired if the lease
+// has expired.
+func (q *LeaseQueue[T]) Nack(lease Lease[T]) error {
+	q.mu.Lock()
+	l, err := q.release(lease)
+	if err != nil {
+		q.mu.Unlock()
+		return err
+	}
+	dead := q.giveBack(l, "not acknowledged")
+	q.notify()
+	q.mu.Unlock()
+
+	q.bury(dead)
+	return nil
+}
+
+// Len returns the number of visible elements in the queue.
+func (q *LeaseQueue[T]) Len() int {
+	q.mu.Lock()
+	dead := q.expire(q.opts.Clock.Now())
+	n := q.visible.Len()
+	q.mu.Unlock()
+
+	q.bury(dead)
+	return n
+}
+
+// Leased returns the number of elements in the queue that are leased.
+func (q *LeaseQueue[T]) Leased() int {
+	q.mu.Lock()
+	dead := q.expire(q.opts.Clock.Now())
+	n := q.leases.Len()
+	q.mu.Unlock()
+
+	q.bury(dead)
+	return n
+}
+
+// release ends a lease that has not expired. It must be called with q.mu held.
+func (q *LeaseQueue[T]) release(lease Lease[T]) (*leased[T], error) {
+	l, ok := q.leasedID[lease.ID]
+	// an expired lease is left to expire, which gives the element back
+	if !ok || l.attempts != lease.Attempt || !l.deadline.After(q.opts.Clock.Now()) {
+		return nil, fmt.Errorf("element %d, attempt %d: %w", lease.ID, lease.Attempt, ErrLeaseExpired)
+	}
+	q.leases.Remove(l.handle)
+	l.handle = nil
+	return l, nil
+}
+
+// expire ends the leases that expired by now, and returns the elements given
+// up on. It must be called with q.mu held.
+func (q *LeaseQueue[T]) expire(now time.Time) []DeadLetter[T] {
+	var dead []DeadLetter[T]
+	for {
+		next, ok := q.leases.Peek()
+		if !ok || next.deadline.After(now) {
+			return dead
+		}
+		q.leases.Pop()
+		next.handle = nil
+		dead = append(dead, q.giveBack(next, "lease expired")...)
+		q.notify()
+	}
+}
+
+// giveBack makes an element whose lease has ended visible again, unless it
+// has been delivered MaxAttempts times, in which case it returns it as a dead
+// letter. It must be called with q.mu held.
+func (q *LeaseQueue[T]) giveBack(l *leased[T], reason string) []DeadLetter[T] {
+	delete(q.leasedID, l.id)
+	if q.opts.MaxAttempts > 0 && l.attempts >= q.opts.MaxAttempts {
+		return []DeadLetter[T]{{
+			Elem:     l.elem,
+			Reason:   fmt.Sprintf("%s after %d attempts", reason, l.attempts),
+			Requeues: l.attempts - 1,
+		}}
+	}
+	q.visible.PushBack(l)
+	return nil
+}
+
+// bury sends elements given up on to the dead-letter queue. It must be called
+// without q.mu held, since the dead-letter queue may block.
+func (q *LeaseQueue[T]) bury(dead []DeadLetter[T]) {
+	if q.opts.DeadLetter == nil {
+		return
+	}
+	for _, d := range dead {
+		q.opts.DeadLetter.Push(d)
+	}
+}
+
+// notify wakes the receives waiting on the queue. It must be called with q.mu
+// held.
+func (q *LeaseQueue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
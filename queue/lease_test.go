@@ -0,0 +1,253 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseQueue(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewLease(LeaseOptions[string]{Visibility: time.Minute, Clock: clock})
+	ctx := context.Background()
+
+	require.NoError(t, q.Push("a"))
+	require.NoError(t, q.Push("b"))
+	assert.Equal(t, 2, q.Len())
+
+	a, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Lease[string]{Elem: "a", ID: 1, Attempt: 1, Deadline: clock.Now().Add(time.Minute)}, a)
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, 1, q.Leased())
+
+	b, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", b.Elem)
+
+	// an acknowledged element is gone; a second Ack of it fails
+	assert.NoError(t, q.Ack(a))
+	assert.ErrorIs(t, q.Ack(a), ErrLeaseExpired)
+
+	// a nacked element is delivered again, as a new attempt
+	assert.NoError(t, q.Nack(b))
+	assert.Equal(t, 1, q.Len())
+	b2, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Lease[string]{Elem: "b", ID: 2, Attempt: 2, Deadline: clock.Now().Add(time.Minute)}, b2)
+	assert.ErrorIs(t, q.Ack(b), ErrLeaseExpired)
+	assert.NoError(t, q.Ack(b2))
+
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, 0, q.Leased())
+}
+
+func TestLeaseQueueVisibility(t *testing.T) {
+
+	var tests = map[string]struct {
+		advance    time.Duration
+		expVisible int
+		expAck     error
+	}{
+		"before deadline": {
+			advance:    59 * time.Second,
+			expVisible: 0,
+			expAck:     nil,
+		},
+		"at deadline": {
+			advance:    time.Minute,
+			expVisible: 1,
+			expAck:     ErrLeaseExpired,
+		},
+		"after deadline": {
+			advance:    time.Hour,
+			expVisible: 1,
+			expAck:     ErrLeaseExpired,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			q := NewLease(LeaseOptions[int]{Visibility: time.Minute, Clock: clock})
+			q.Push(1)
+			lease, err := q.Receive(context.Background())
+			require.NoError(t, err)
+
+			clock.Advance(tt.advance)
+			assert.Equal(t, tt.expVisible, q.Len())
+			assert.Equal(t, 1-tt.expVisible, q.Leased())
+			if tt.expAck == nil {
+				assert.NoError(t, q.Ack(lease))
+			} else {
+				assert.ErrorIs(t, q.Ack(lease), tt.expAck)
+				assert.ErrorIs(t, q.Nack(lease), tt.expAck)
+			}
+		})
+	}
+}
+
+func TestLeaseQueueReceiveWaits(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewLease(LeaseOptions[int]{Visibility: time.Minute, Clock: clock})
+	q.Push(1)
+	first, err := q.Receive(context.Background())
+	require.NoError(t, err)
+
+	// a receive waits for the lease to expire, and gets the element again
+	received := make(chan Lease[int])
+	go func() {
+		lease, _ := q.Receive(context.Background())
+		received <- lease
+	}()
+	clock.WaitTimer(t)
+	clock.Advance(30 * time.Second)
+	select {
+	case <-received:
+		t.Fatal("received before the lease expired")
+	default:
+	}
+	clock.Advance(30 * time.Second)
+	second := <-received
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, 2, second.Attempt)
+
+	// or for an element to be pushed
+	go func() {
+		lease, _ := q.Receive(context.Background())
+		received <- lease
+	}()
+	clock.WaitTimer(t)
+	q.Push(2)
+	assert.Equal(t, 2, (<-received).Elem)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.Receive(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLeaseQueueDeadLetter(t *testing.T) {
+
+	var tests = map[string]struct {
+		fail    func(q *LeaseQueue[string], clock *fakeClock, l Lease[string])
+		expDead []DeadLetter[string]
+	}{
+		"nacked": {
+			fail: func(q *LeaseQueue[string], clock *fakeClock, l Lease[string]) {
+				q.Nack(l)
+			},
+			expDead: []DeadLetter[string]{
+				{Elem: "poison", Reason: "not acknowledged after 3 attempts", Requeues: 2},
+			},
+		},
+		"expired": {
+			fail: func(q *LeaseQueue[string], clock *fakeClock, l Lease[string]) {
+				clock.Advance(time.Minute)
+			},
+			expDead: []DeadLetter[string]{
+				{Elem: "poison", Reason: "lease expired after 3 attempts", Requeues: 2},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			dead := New[DeadLetter[string]](10)
+			q := NewLease(LeaseOptions[string]{Visibility: time.Minute, MaxAttempts: 3, DeadLetter: dead, Clock: clock})
+			q.Push("poison")
+			q.Push("good")
+
+			// the poison element fails every attempt, while the good one is
+			// processed at the second
+			for attempt := 1; attempt <= 3; attempt++ {
+				for i := 0; i < 2 && q.Len() > 0; i++ {
+					l, err := q.Receive(context.Background())
+					require.NoError(t, err)
+					if l.Elem == "good" && attempt == 2 {
+						assert.NoError(t, q.Ack(l))
+						continue
+					}
+					tt.fail(q, clock, l)
+				}
+			}
+
+			assert.Equal(t, 0, q.Len())
+			assert.Equal(t, 0, q.Leased())
+			assert.Equal(t, tt.expDead, dead.Drain())
+		})
+	}
+}
+
+func TestLeaseQueueClose(t *testing.T) {
+
+	clock := newFakeClock()
+	q := NewLease(LeaseOptions[int]{Clock: clock})
+	q.Push(1)
+	q.Close()
+	q.Close()
+	assert.ErrorIs(t, q.Push(2), ErrClosed)
+
+	// a leased element keeps receives waiting, since it may be given back
+	lease, err := q.Receive(context.Background())
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := q.Receive(context.Background())
+		done <- err
+	}()
+	clock.WaitTimer(t)
+	assert.NoError(t, q.Ack(lease))
+	assert.ErrorIs(t, <-done, ErrClosed)
+}
+
+func TestLeaseQueueConcurrent(t *testing.T) {
+
+	const workers, items = 4, 200
+	dead := New[DeadLetter[int]](items)
+	q := NewLease(LeaseOptions[int]{Visibility: time.Hour, MaxAttempts: 2, DeadLetter: dead})
+	for i := 0; i < items; i++ {
+		q.Push(i)
+	}
+	q.Close()
+
+	// multiples of 10 always fail, others fail at their first attempt
+	var mu sync.Mutex
+	acked := map[int]int{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				l, err := q.Receive(context.Background())
+				if err != nil {
+					assert.ErrorIs(t, err, ErrClosed)
+					return
+				}
+				if l.Elem%10 == 0 || l.Attempt == 1 {
+					assert.NoError(t, q.Nack(l))
+					continue
+				}
+				assert.NoError(t, q.Ack(l))
+				mu.Lock()
+				acked[l.Elem]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, acked, items-items/10)
+	for _, n := range acked {
+		assert.Equal(t, 1, n)
+	}
+	assert.Equal(t, items/10, dead.Len())
+}
@@ -0,0 +1,204 @@
+package queue
+
+import "sync"
+
+// Handle refers to an element in a PriorityQueue, so that the element can be
+// updated or removed.
+type Handle[T any] struct {
+	elem  T
+	index int // position in the heap, or -1 once popped or removed
+}
+
+// Value returns the element the handle refers to.
+func (h *Handle[T]) Value() T {
+	return h.elem
+}
+
+// PriorityQueue is a queue that pops its elements in order of priority, as
+// given by a comparator, stored in a binary heap. Elements of equal priority
+// are popped in no particular order. The zero value is not usable; create one
+// with NewPriority.
+//
+// A PriorityQueue is not safe for concurrent use; see SyncPriorityQueue.
+type PriorityQueue[T any] struct {
+	less  func(a, b T) bool
+	items []*Handle[T]
+}
+
+// NewPriority returns an empty priority queue. The function less reports
+// whether a has a higher priority than b, that is whether a is to be popped
+// before b.
+func NewPriority[T any](less func(a, b T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{less: less}
+}
+
+// Len returns the number of elements in the queue.
+func (q *PriorityQueue[T]) Len() int {
+	return len(q.items)
+}
+
+// Push adds an element to the queue, and returns a handle to it.
+func (q *PriorityQueue[T]) Push(elem T) *Handle[T] {
+	h := &Handle[T]{elem: elem, index: len(q.items)}
+	q.items = append(q.items, h)
+	q.up(h.index)
+	return h
+}
+
+// Pop removes and returns the element of highest priority. It returns false if
+// the queue is empty.
+func (q *PriorityQueue[T]) Pop() (elem T, ok bool) {
+	if len(q.items) == 0 {
+		return elem, false
+	}
+	return q.remove(0), true
+}
+
+// Peek returns the element of highest priority without removing it. It returns
+// false if the queue is empty.
+func (q *PriorityQueue[T]) Peek() (elem T, ok bool) {
+	if len(q.items) == 0 {
+		return elem, false
+	}
+	return q.items[0].elem, true
+}
+
+// Update replaces the element of a handle, typically with one of a new
+// priority, and moves it to its place in the queue. It returns false if the
+// element is no longer in the queue.
+func (q *PriorityQueue[T]) Update(h *Handle[T], elem T) bool {
+	if !q.contains(h) {
+		return false
+	}
+	h.elem = elem
+	if !q.down(h.index) {
+		q.up(h.index)
+	}
+	return true
+}
+
+// Remove removes the element of a handle from the queue and returns it. It
+// returns false if the element is no longer in the queue.
+func (q *PriorityQueue[T]) Remove(h *Handle[T]) (elem T, ok bool) {
+	if !q.contains(h) {
+		return elem, false
+	}
+	return q.remove(h.index), true
+}
+
+func (q *PriorityQueue[T]) contains(h *Handle[T]) bool {
+	return h != nil && h.index >= 0 && h.index < len(q.items) && q.items[h.index] == h
+}
+
+// remove removes the element at position i of the heap.
+func (q *PriorityQueue[T]) remove(i int) T {
+	h := q.items[i]
+	last := len(q.items) - 1
+	if i != last {
+		q.swap(i, last)
+	}
+	q.items[last] = nil
+	q.items = q.items[:last]
+	if i != last {
+		if !q.down(i) {
+			q.up(i)
+		}
+	}
+	h.index = -1
+	return h.elem
+}
+
+func (q *PriorityQueue[T]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !q.less(q.items[i].elem, q.items[parent].elem) {
+			return
+		}
+		q.swap(i, parent)
+		i = parent
+	}
+}
+
+// down moves the element at position i down the heap, and reports whether it
+// moved.
+func (q *PriorityQueue[T]) down(i int) bool {
+	start := i
+	for {
+		best := i
+		if l := 2*i + 1; l < len(q.items) && q.less(q.items[l].elem, q.items[best].elem) {
+			best = l
+		}
+		if r := 2*i + 2; r < len(q.items) && q.less(q.items[r].elem, q.items[best].elem) {
+			best = r
+		}
+		if best == i {
+			return i != start
+		}
+		q.swap(i, best)
+		i = best
+	}
+}
+
+func (q *PriorityQueue[T]) swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+// SyncPriorityQueue is a PriorityQueue that is safe for concurrent use.
+type SyncPriorityQueue[T any] struct {
+	mu sync.Mutex
+	q  *PriorityQueue[T]
+}
+
+// NewSyncPriority returns an empty priority queue that is safe for concurrent
+// use. See NewPriority.
+func NewSyncPriority[T any](less func(a, b T) bool) *SyncPriorityQueue[T] {
+	return &SyncPriorityQueue[T]{q: NewPriority(less)}
+}
+
+// Len returns the number of elements in the queue.
+func (s *SyncPriorityQueue[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.q.Len()
+}
+
+// Push adds an element to the queue, and returns a handle to it.
+func (s *SyncPriorityQueue[T]) Push(elem T) *Handle[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.q.Push(elem)
+}
+
+// Pop removes and returns the element of highest priority. It returns false if
+// the queue is empty.
+func (s *SyncPriorityQueue[T]) Pop() (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.q.Pop()
+}
+
+// Peek returns the element of highest priority without removing it. It returns
+// false if the queue is empty.
+func (s *SyncPriorityQueue[T]) Peek() (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.q.Peek()
+}
+
+// Update replaces the element of a handle and moves it to its place in the
+// queue. It returns false if the element is no longer in the queue.
+func (s *SyncPriorityQueue[T]) Update(h *Handle[T], elem T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.q.Update(h, elem)
+}
+
+// Remove removes the element of a handle from the queue and returns it. It
+// returns false if the element is no longer in the queue.
+func (s *SyncPriorityQueue[T]) Remove(h *Handle[T]) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.q.Remove(h)
+}
@@ -0,0 +1,175 @@
+package queue
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type task struct {
+	name     string
+	priority int
+}
+
+func byPriority(a, b task) bool {
+	return a.priority < b.priority
+}
+
+func popAll(q *PriorityQueue[task]) []string {
+	names := []string{}
+	for {
+		t, ok := q.Pop()
+		if !ok {
+			return names
+		}
+		names = append(names, t.name)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+
+	var tests = map[string]struct {
+		ops      func(*PriorityQueue[task])
+		expOrder []string
+	}{
+		"empty": {
+			ops:      func(q *PriorityQueue[task]) {},
+			expOrder: []string{},
+		},
+		"push": {
+			ops: func(q *PriorityQueue[task]) {
+				q.Push(task{"c", 3})
+				q.Push(task{"a", 1})
+				q.Push(task{"d", 4})
+				q.Push(task{"b", 2})
+			},
+			expOrder: []string{"a", "b", "c", "d"},
+		},
+		"raise priority": {
+			ops: func(q *PriorityQueue[task]) {
+				q.Push(task{"a", 1})
+				q.Push(task{"b", 2})
+				h := q.Push(task{"c", 3})
+				assert.True(t, q.Update(h, task{"c", 0}))
+			},
+			expOrder: []string{"c", "a", "b"},
+		},
+		"lower priority": {
+			ops: func(q *PriorityQueue[task]) {
+				h := q.Push(task{"a", 1})
+				q.Push(task{"b", 2})
+				q.Push(task{"c", 3})
+				assert.True(t, q.Update(h, task{"a", 5}))
+			},
+			expOrder: []string{"b", "c", "a"},
+		},
+		"remove": {
+			ops: func(q *PriorityQueue[task]) {
+				q.Push(task{"a", 1})
+				h := q.Push(task{"b", 2})
+				q.Push(task{"c", 3})
+				e, ok := q.Remove(h)
+				assert.True(t, ok)
+				assert.Equal(t, "b", e.name)
+				_, ok = q.Remove(h)
+				assert.False(t, ok)
+				assert.False(t, q.Update(h, task{"b", 0}))
+			},
+			expOrder: []string{"a", "c"},
+		},
+		"popped handle": {
+			ops: func(q *PriorityQueue[task]) {
+				h := q.Push(task{"a", 1})
+				q.Push(task{"b", 2})
+				q.Pop()
+				assert.False(t, q.Update(h, task{"a", 0}))
+				assert.Equal(t, "a", h.Value().name)
+			},
+			expOrder: []string{"b"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := NewPriority(byPriority)
+			tt.ops(q)
+			assert.Equal(t, len(tt.expOrder), q.Len())
+			if len(tt.expOrder) > 0 {
+				e, ok := q.Peek()
+				assert.True(t, ok)
+				assert.Equal(t, tt.expOrder[0], e.name)
+			} else {
+				_, ok := q.Peek()
+				assert.False(t, ok)
+			}
+			assert.Equal(t, tt.expOrder, popAll(q))
+		})
+	}
+}
+
+// TestPriorityQueueRandom checks the order of a priority queue against a
+// sorted slice through random pushes, updates and removals.
+func TestPriorityQueueRandom(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+	q := NewPriority(func(a, b int) bool { return a < b })
+	handles := []*Handle[int]{}
+
+	for i := 0; i < 1000; i++ {
+		handles = append(handles, q.Push(rng.Intn(1000)))
+	}
+	for i := 0; i < 300; i++ {
+		q.Update(handles[rng.Intn(len(handles))], rng.Intn(1000))
+	}
+	for i := 0; i < 300; i++ {
+		q.Remove(handles[rng.Intn(len(handles))])
+	}
+
+	exp := []int{}
+	for _, h := range handles {
+		if h.index >= 0 {
+			exp = append(exp, h.Value())
+		}
+	}
+	sort.Ints(exp)
+
+	got := []int{}
+	for q.Len() > 0 {
+		e, _ := q.Pop()
+		got = append(got, e)
+	}
+	assert.Equal(t, exp, got)
+}
+
+func TestSyncPriorityQueue(t *testing.T) {
+
+	q := NewSyncPriority(func(a, b int) bool { return a > b })
+
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				h := q.Push(p*100 + i)
+				if i%10 == 0 {
+					q.Update(h, -1)
+				}
+				q.Peek()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 400, q.Len())
+	e, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 399, e)
+	h := q.Push(1000)
+	e, ok = q.Remove(h)
+	assert.True(t, ok)
+	assert.Equal(t, 1000, e)
+}
@@ -1,19 +1,307 @@
+// Package queue implements a single ended queue backed by a channel, which
+// makes it safe for concurrent use by any number of producers and consumers.
 package queue
 
-type queue struct {
-	q chan interface{}
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrClosed is returned when pushing to a queue that has been closed, or
+	// popping from one that has been closed and is empty.
+	ErrClosed = errors.New("queue is closed")
+	// ErrFull is returned when pushing to a full queue with the OverflowError
+	// policy.
+	ErrFull = errors.New("queue is full")
+)
+
+// OverflowPolicy decides what a push does when the queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes a push block until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the element being pushed.
+	OverflowDropNewest
+	// OverflowDropOldest drops the element at the front of the queue to make
+	// room for the element being pushed. A queue of capacity zero has no
+	// element to drop, and drops the element being pushed instead.
+	OverflowDropOldest
+	// OverflowError makes a push return ErrFull, without adding the element.
+	OverflowError
+)
+
+// Queue is a first-in, first-out queue of bounded capacity.
+//
+// A queue can be closed, to tell consumers that no more elements will be
+// pushed. Elements already in the queue can still be popped after it is
+// closed; pops report that the queue is closed only once it is also empty.
+type Queue[T any] struct {
+	dropped uint64 // accessed atomically, first for alignment
+	q       chan T
+	done    chan struct{}
+	closed  sync.Once
+	policy  OverflowPolicy
+	clock   Clock
+
+	// pushing is held for reading by every push while it runs, so that Close
+	// and the pops that find the queue closed can wait for the pushes in
+	// progress to end, and no element is added once they see it closed
+	pushing sync.RWMutex
+}
+
+// New returns an empty queue holding up to capacity elements. A queue with a
+// capacity of zero holds no elements: every push blocks until a pop takes the
+// element.
+func New[T any](capacity int) *Queue[T] {
+	return NewWithPolicy[T](capacity, OverflowBlock)
 }
 
-func new(capacity int) *queue {
-	return &queue{
-		q: make(chan interface{}, capacity),
+// NewWithPolicy returns an empty queue holding up to capacity elements, whose
+// pushes follow the given policy when the queue is full. Producers that must
+// never be held up, such as telemetry, can drop elements instead of blocking.
+func NewWithPolicy[T any](capacity int, policy OverflowPolicy) *Queue[T] {
+	return &Queue[T]{
+		q:      make(chan T, capacity),
+		done:   make(chan struct{}),
+		policy: policy,
+		clock:  SystemClock,
 	}
 }
 
-func (q *queue) push(elem interface{}) {
-	q.q <- elem
+// SetClock sets the clock that times the waits of PopBatch, which is
+// SystemClock unless set. It must be called before the queue is used.
+func (q *Queue[T]) SetClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	q.clock = clock
+}
+
+// Close closes the queue. Pushes blocked on a full queue return ErrClosed, as
+// do all pushes after Close returns, and pops blocked on an empty queue
+// return. A push in progress may still add its element before it returns;
+// Close waits for it, so that after Close returns, Drain collects every
+// element added and not popped. Closing a closed queue has no effect.
+func (q *Queue[T]) Close() {
+	q.closed.Do(func() { close(q.done) })
+	q.settle()
+}
+
+// settle waits for the pushes in progress to end. Once the queue is closed,
+// no element is added after it returns.
+func (q *Queue[T]) settle() {
+	q.pushing.Lock()
+	q.pushing.Unlock()
+}
+
+// Push adds an element to the back of the queue, blocking while the queue is
+// full unless the queue has another overflow policy. It returns ErrClosed if
+// the queue is closed.
+func (q *Queue[T]) Push(elem T) error {
+	return q.PushContext(context.Background(), elem)
+}
+
+// Pop removes the element at the front of the queue, blocking while the queue
+// is empty. It returns false if the queue is closed and empty.
+func (q *Queue[T]) Pop() (T, bool) {
+	elem, err := q.PopContext(context.Background())
+	return elem, err == nil
+}
+
+// TryPush adds an element to the back of the queue if it is neither full nor
+// closed, and reports whether it did, whatever the overflow policy of the
+// queue.
+func (q *Queue[T]) TryPush(elem T) bool {
+	q.pushing.RLock()
+	defer q.pushing.RUnlock()
+
+	select {
+	case <-q.done:
+		return false
+	default:
+	}
+	return q.offer(elem)
+}
+
+// offer adds an element to the back of the queue if it is not full, and
+// reports whether it did.
+func (q *Queue[T]) offer(elem T) bool {
+	select {
+	case q.q <- elem:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryPop removes the element at the front of the queue if it is not empty,
+// and reports whether it did.
+func (q *Queue[T]) TryPop() (elem T, ok bool) {
+	select {
+	case elem = <-q.q:
+		return elem, true
+	default:
+		return elem, false
+	}
+}
+
+// PushContext adds an element to the back of the queue, blocking while the
+// queue is full until ctx is done, in which case it returns the error of ctx.
+// It returns ErrClosed if the queue is closed. A queue with an overflow policy
+// other than OverflowBlock never blocks, and ignores ctx.
+func (q *Queue[T]) PushContext(ctx context.Context, elem T) error {
+
+	q.pushing.RLock()
+	defer q.pushing.RUnlock()
+
+	// checked first, so that no push succeeds once Close has returned
+	select {
+	case <-q.done:
+		return ErrClosed
+	default:
+	}
+
+	switch q.policy {
+	case OverflowDropNewest:
+		if !q.offer(elem) {
+			atomic.AddUint64(&q.dropped, 1)
+		}
+		return nil
+
+	case OverflowDropOldest:
+		for !q.offer(elem) {
+			if cap(q.q) == 0 {
+				atomic.AddUint64(&q.dropped, 1)
+				return nil
+			}
+			// a consumer may take the oldest element first, leaving room
+			if _, ok := q.TryPop(); ok {
+				atomic.AddUint64(&q.dropped, 1)
+			}
+		}
+		return nil
+
+	case OverflowError:
+		if !q.offer(elem) {
+			atomic.AddUint64(&q.dropped, 1)
+			return ErrFull
+		}
+		return nil
+	}
+
+	select {
+	case q.q <- elem:
+		return nil
+	case <-q.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PopContext removes the element at the front of the queue, blocking while the
+// queue is empty until ctx is done, in which case it returns the error of ctx.
+// It returns ErrClosed if the queue is closed and empty.
+func (q *Queue[T]) PopContext(ctx context.Context) (elem T, err error) {
+	select {
+	case elem = <-q.q:
+		return elem, nil
+	case <-q.done:
+		// elements pushed before the queue was closed are still popped,
+		// including those of pushes still in progress
+		q.settle()
+		if elem, ok := q.TryPop(); ok {
+			return elem, nil
+		}
+		return elem, ErrClosed
+	case <-ctx.Done():
+		return elem, ctx.Err()
+	}
+}
+
+// PopBatch removes up to max elements from the front of the queue, for callers
+// that process elements in batches. It blocks while the queue is empty, then
+// returns as soon as it has max elements, or once wait has passed since it got
+// the first, whichever comes first.
+//
+// It returns the elements it has, possibly none, as soon as ctx is done, or
+// once the queue is closed and empty.
+func (q *Queue[T]) PopBatch(ctx context.Context, max int, wait time.Duration) []T {
+
+	batch := []T{}
+	if max <= 0 {
+		return batch
+	}
+
+	first, err := q.PopContext(ctx)
+	if err != nil {
+		return batch
+	}
+	batch = append(batch, first)
+
+	timer := q.clock.NewTimer(wait)
+	defer timer.Stop()
+
+	for len(batch) < max {
+		// elements already queued are taken before checking the timer
+		if elem, ok := q.TryPop(); ok {
+			batch = append(batch, elem)
+			continue
+		}
+
+		select {
+		case elem := <-q.q:
+			batch = append(batch, elem)
+		case <-q.done:
+			q.settle()
+			for len(batch) < max {
+				elem, ok := q.TryPop()
+				if !ok {
+					break
+				}
+				batch = append(batch, elem)
+			}
+			return batch
+		case <-timer.C():
+			return batch
+		case <-ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+// Drain removes and returns all elements in the queue, without blocking. It
+// is typically called after Close, to collect the elements no consumer
+// popped.
+func (q *Queue[T]) Drain() []T {
+	elems := []T{}
+	for {
+		elem, ok := q.TryPop()
+		if !ok {
+			return elems
+		}
+		elems = append(elems, elem)
+	}
+}
+
+// Len returns the number of elements in the queue.
+func (q *Queue[T]) Len() int {
+	return len(q.q)
+}
+
+// Dropped returns the number of elements the overflow policy of the queue has
+// dropped, or with OverflowError, refused.
+func (q *Queue[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
 }
 
-func (q *queue) pop() interface{} {
-	return <-q.q
+// Cap returns the capacity of the queue.
+func (q *Queue[T]) Cap() int {
+	return cap(q.q)
 }
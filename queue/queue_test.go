@@ -0,0 +1,538 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+
+	var tests = map[string]struct {
+		capacity int
+		push     []int
+	}{
+		"empty": {
+			capacity: 3,
+			push:     []int{},
+		},
+		"partly full": {
+			capacity: 3,
+			push:     []int{1, 2},
+		},
+		"full": {
+			capacity: 3,
+			push:     []int{1, 2, 3},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := New[int](tt.capacity)
+			for _, e := range tt.push {
+				assert.NoError(t, q.Push(e))
+			}
+			assert.Equal(t, len(tt.push), q.Len())
+			assert.Equal(t, tt.capacity, q.Cap())
+
+			got := []int{}
+			for q.Len() > 0 {
+				e, ok := q.Pop()
+				assert.True(t, ok)
+				got = append(got, e)
+			}
+			assert.Equal(t, tt.push, got)
+		})
+	}
+}
+
+func TestQueueTry(t *testing.T) {
+
+	q := New[string](1)
+
+	_, ok := q.TryPop()
+	assert.False(t, ok)
+
+	assert.True(t, q.TryPush("a"))
+	assert.False(t, q.TryPush("b"))
+
+	e, ok := q.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, "a", e)
+}
+
+func TestQueueContext(t *testing.T) {
+
+	q := New[int](1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := q.PopContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, q.PushContext(context.Background(), 1))
+	assert.ErrorIs(t, q.PushContext(ctx, 2), context.DeadlineExceeded)
+
+	e, err := q.PopContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, e)
+}
+
+func TestQueueConcurrent(t *testing.T) {
+
+	const producers, items = 4, 250
+	q := New[int](8)
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < items; i++ {
+				q.Push(p*items + i)
+			}
+		}(p)
+	}
+
+	seen := map[int]bool{}
+	for i := 0; i < producers*items; i++ {
+		e, _ := q.Pop()
+		seen[e] = true
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, producers*items)
+	assert.Zero(t, q.Len())
+}
+
+func TestQueueClose(t *testing.T) {
+
+	q := New[int](3)
+	q.Push(1)
+	q.Push(2)
+	q.Close()
+	q.Close()
+
+	assert.ErrorIs(t, q.Push(3), ErrClosed)
+	assert.ErrorIs(t, q.PushContext(context.Background(), 3), ErrClosed)
+	assert.False(t, q.TryPush(3))
+
+	// elements pushed before closing are still popped
+	e, ok := q.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, e)
+	assert.Equal(t, []int{2}, q.Drain())
+
+	_, ok = q.Pop()
+	assert.False(t, ok)
+	_, err := q.PopContext(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.Equal(t, []int{}, q.Drain())
+}
+
+func TestQueueCloseUnblocks(t *testing.T) {
+
+	empty := New[int](1)
+	full := New[int](1)
+	full.Push(1)
+
+	popped := make(chan bool)
+	pushed := make(chan error)
+	go func() {
+		_, ok := empty.Pop()
+		popped <- ok
+	}()
+	go func() {
+		pushed <- full.Push(2)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	empty.Close()
+	full.Close()
+
+	assert.False(t, <-popped)
+	assert.ErrorIs(t, <-pushed, ErrClosed)
+	assert.Equal(t, []int{1}, full.Drain())
+}
+
+func TestQueueCloseRace(t *testing.T) {
+
+	// a push in progress when the queue is closed either fails, or adds its
+	// element before Close returns, so that no element is lost
+	const pushers = 8
+	for i := 0; i < 500; i++ {
+		q := New[int](64)
+
+		var pushed int64
+		var wg sync.WaitGroup
+		for p := 0; p < pushers; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for q.Push(1) == nil {
+					atomic.AddInt64(&pushed, 1)
+				}
+			}()
+		}
+		popped := make(chan int, 1)
+		go func() {
+			n := 0
+			for {
+				if _, ok := q.Pop(); !ok {
+					popped <- n
+					return
+				}
+				n++
+			}
+		}()
+
+		time.Sleep(10 * time.Microsecond)
+		q.Close()
+		drained := len(q.Drain())
+		n := <-popped + drained
+		wg.Wait()
+		require.Equal(t, atomic.LoadInt64(&pushed), int64(n), "iteration %d", i)
+	}
+}
+
+func TestQueueWorkers(t *testing.T) {
+
+	const workers, items = 4, 100
+	q := New[int](4)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sum := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				e, ok := q.Pop()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				sum += e
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 1; i <= items; i++ {
+		assert.NoError(t, q.Push(i))
+	}
+	q.Close()
+	wg.Wait()
+
+	assert.Equal(t, items*(items+1)/2, sum)
+}
+
+func TestQueuePopBatch(t *testing.T) {
+
+	var tests = map[string]struct {
+		queued  []int
+		max     int
+		wait    time.Duration
+		advance bool
+		close   bool
+		exp     []int
+	}{
+		"max reached": {
+			queued: []int{1, 2, 3, 4, 5},
+			max:    3,
+			wait:   time.Hour,
+			exp:    []int{1, 2, 3},
+		},
+		"wait passed": {
+			queued:  []int{1, 2},
+			max:     5,
+			wait:    time.Minute,
+			advance: true,
+			exp:     []int{1, 2},
+		},
+		"no wait": {
+			queued: []int{1, 2, 3},
+			max:    5,
+			wait:   0,
+			exp:    []int{1, 2, 3},
+		},
+		"closed": {
+			queued: []int{1, 2},
+			max:    5,
+			wait:   time.Hour,
+			close:  true,
+			exp:    []int{1, 2},
+		},
+		"closed and empty": {
+			queued: []int{},
+			max:    5,
+			wait:   time.Hour,
+			close:  true,
+			exp:    []int{},
+		},
+		"zero max": {
+			queued: []int{1},
+			max:    0,
+			wait:   time.Hour,
+			exp:    []int{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			q := New[int](10)
+			q.SetClock(clock)
+			for _, e := range tt.queued {
+				q.Push(e)
+			}
+			if tt.close {
+				q.Close()
+			}
+
+			batch := make(chan []int)
+			go func() { batch <- q.PopBatch(context.Background(), tt.max, tt.wait) }()
+			if tt.advance {
+				clock.WaitTimer(t)
+				clock.Advance(tt.wait)
+			}
+			assert.Equal(t, tt.exp, <-batch)
+		})
+	}
+}
+
+// blockingContext is a context that reports when a select first waits on it.
+type blockingContext struct {
+	context.Context
+	waiting chan struct{}
+	once    sync.Once
+}
+
+func newBlockingContext(ctx context.Context) *blockingContext {
+	return &blockingContext{Context: ctx, waiting: make(chan struct{})}
+}
+
+func (c *blockingContext) Done() <-chan struct{} {
+	c.once.Do(func() { close(c.waiting) })
+	return c.Context.Done()
+}
+
+func TestQueuePopBatchWaits(t *testing.T) {
+
+	clock := newFakeClock()
+	// with no capacity, a push returns only once the batch has taken the
+	// element
+	q := New[int](0)
+	q.SetClock(clock)
+
+	ctx := newBlockingContext(context.Background())
+	batches := make(chan []int)
+	go func() {
+		batches <- q.PopBatch(ctx, 3, time.Minute)
+	}()
+
+	// the wait counts from the first element, not from the call: time that
+	// passes while the batch waits for its first element does not count
+	<-ctx.waiting
+	clock.Advance(time.Hour)
+	q.Push(1)
+	clock.WaitTimer(t)
+
+	clock.Advance(59 * time.Second)
+	q.Push(2)
+	select {
+	case b := <-batches:
+		t.Fatalf("batch %v returned before the wait passed", b)
+	default:
+	}
+	clock.Advance(time.Second)
+	assert.Equal(t, []int{1, 2}, <-batches)
+
+	// the batch returns as soon as it is full, without waiting
+	go func() {
+		batches <- q.PopBatch(context.Background(), 3, time.Minute)
+	}()
+	for i := 1; i <= 3; i++ {
+		q.Push(i)
+	}
+	assert.Equal(t, []int{1, 2, 3}, <-batches)
+}
+
+func TestQueuePopBatchContext(t *testing.T) {
+
+	clock := newFakeClock()
+	q := New[int](10)
+	q.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, []int{}, q.PopBatch(ctx, 3, time.Hour))
+
+	// a batch already started is returned when ctx is done
+	q.Push(1)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	batches := make(chan []int)
+	go func() {
+		batches <- q.PopBatch(ctx, 3, time.Hour)
+	}()
+	clock.WaitTimer(t)
+	cancel()
+	assert.Equal(t, []int{1}, <-batches)
+}
+
+func TestQueueOverflow(t *testing.T) {
+
+	var tests = map[string]struct {
+		policy     OverflowPolicy
+		capacity   int
+		pushes     []int
+		expErrs    []error
+		expQueued  []int
+		expDropped uint64
+	}{
+		"drop newest": {
+			policy:     OverflowDropNewest,
+			capacity:   2,
+			pushes:     []int{1, 2, 3, 4},
+			expErrs:    []error{nil, nil, nil, nil},
+			expQueued:  []int{1, 2},
+			expDropped: 2,
+		},
+		"drop oldest": {
+			policy:     OverflowDropOldest,
+			capacity:   2,
+			pushes:     []int{1, 2, 3, 4},
+			expErrs:    []error{nil, nil, nil, nil},
+			expQueued:  []int{3, 4},
+			expDropped: 2,
+		},
+		"drop oldest with no capacity": {
+			policy:     OverflowDropOldest,
+			capacity:   0,
+			pushes:     []int{1, 2},
+			expErrs:    []error{nil, nil},
+			expQueued:  []int{},
+			expDropped: 2,
+		},
+		"error": {
+			policy:     OverflowError,
+			capacity:   2,
+			pushes:     []int{1, 2, 3, 4},
+			expErrs:    []error{nil, nil, ErrFull, ErrFull},
+			expQueued:  []int{1, 2},
+			expDropped: 2,
+		},
+		"not full": {
+			policy:     OverflowError,
+			capacity:   5,
+			pushes:     []int{1, 2, 3},
+			expErrs:    []error{nil, nil, nil},
+			expQueued:  []int{1, 2, 3},
+			expDropped: 0,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := NewWithPolicy[int](tt.capacity, tt.policy)
+			for i, e := range tt.pushes {
+				err := q.Push(e)
+				if tt.expErrs[i] == nil {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, tt.expErrs[i])
+				}
+			}
+			assert.Equal(t, tt.expQueued, q.Drain())
+			assert.Equal(t, tt.expDropped, q.Dropped())
+		})
+	}
+}
+
+func TestQueueOverflowBlock(t *testing.T) {
+
+	q := NewWithPolicy[int](1, OverflowBlock)
+	q.Push(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.PushContext(ctx, 2), context.DeadlineExceeded)
+	assert.Equal(t, uint64(0), q.Dropped())
+
+	// other policies do not block, and a closed queue refuses all pushes
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest, OverflowError} {
+		q := NewWithPolicy[int](0, policy)
+		done := make(chan struct{})
+		go func() {
+			q.Push(1)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("policy %d blocked", policy)
+		}
+		q.Close()
+		assert.ErrorIs(t, q.Push(2), ErrClosed)
+	}
+}
+
+func TestQueueOverflowConcurrent(t *testing.T) {
+
+	const producers, consumers, perProducer = 8, 2, 500
+
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest, OverflowError} {
+		q := NewWithPolicy[int](4, policy)
+
+		var popped uint64
+		var cwg sync.WaitGroup
+		for c := 0; c < consumers; c++ {
+			cwg.Add(1)
+			go func() {
+				defer cwg.Done()
+				for {
+					if _, ok := q.Pop(); !ok {
+						return
+					}
+					atomic.AddUint64(&popped, 1)
+				}
+			}()
+		}
+
+		var pwg sync.WaitGroup
+		var refused uint64
+		for p := 0; p < producers; p++ {
+			pwg.Add(1)
+			go func() {
+				defer pwg.Done()
+				for i := 0; i < perProducer; i++ {
+					switch err := q.Push(i); err {
+					case nil:
+					case ErrFull:
+						atomic.AddUint64(&refused, 1)
+					default:
+						t.Errorf("unexpected error %v", err)
+					}
+				}
+			}()
+		}
+		pwg.Wait()
+		q.Close()
+		cwg.Wait()
+
+		// every element is either popped or dropped
+		assert.Equal(t, uint64(producers*perProducer), popped+q.Dropped(), "policy %d", policy)
+		if policy == OverflowError {
+			assert.Equal(t, refused, q.Dropped())
+		} else {
+			assert.Equal(t, uint64(0), refused)
+		}
+	}
+}
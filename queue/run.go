@@ -0,0 +1,158 @@
+package queue
+
+import "sync"
+
+// DeadLetter is an element that was given up on, along with the reason.
+type DeadLetter[T any] struct {
+	Elem T
+	// Reason explains why the element was given up on.
+	Reason string
+	// Requeues is the number of times the element had been requeued.
+	Requeues int
+}
+
+// CountedQueue wraps a queue to count how many times each element is pushed
+// back into it, as when a consumer fails to process an element and requeues
+// it for a later attempt. An element requeued more than a limit is sent to a
+// dead-letter queue instead, so that elements that can never be processed do
+// not cycle through the queue forever.
+//
+// Elements are told apart by a key. The count of an element is kept until the
+// element is marked done or sent to the dead-letter queue. A CountedQueue is
+// safe for concurrent use.
+type CountedQueue[T any, K comparable] struct {
+	q     *Queue[T]
+	dead  *Queue[DeadLetter[T]]
+	limit int
+	key   func(T) K
+
+	mu       sync.Mutex
+	counts   map[K]int
+	deadSent int
+}
+
+// NewCounted wraps queue q so that an element can be requeued up to limit
+// times before it is sent to queue dead. The function key returns the key of
+// an element.
+//
+// Sending to the dead-letter queue blocks while it is full, so it should be
+// consumed or have enough capacity.
+func NewCounted[T any, K comparable](q *Queue[T], dead *Queue[DeadLetter[T]], limit int, key func(T) K) *CountedQueue[T, K] {
+	return &CountedQueue[T, K]{
+		q:      q,
+		dead:   dead,
+		limit:  limit,
+		key:    key,
+		counts: map[K]int{},
+	}
+}
+
+// Push adds a new element to the queue. See Queue.Push.
+func (c *CountedQueue[T, K]) Push(elem T) error {
+	return c.q.Push(elem)
+}
+
+// Pop removes the element at the front of the queue. See Queue.Pop.
+func (c *CountedQueue[T, K]) Pop() (T, bool) {
+	return c.q.Pop()
+}
+
+// Requeue pushes an element back into the queue after a failed attempt to
+// process it, for the given reason. If the element has already been requeued
+// limit times, it is sent to the dead-letter queue instead, and Requeue
+// returns false. If the push fails, the counts are left as they were.
+func (c *CountedQueue[T, K]) Requeue(elem T, reason string) (bool, error) {
+
+	k := c.key(elem)
+
+	c.mu.Lock()
+	n := c.counts[k]
+	if n >= c.limit {
+		return false, c.bury(k, n, DeadLetter[T]{Elem: elem, Reason: "requeue limit exceeded: " + reason, Requeues: n})
+	}
+	c.counts[k] = n + 1
+	c.mu.Unlock()
+
+	if err := c.q.Push(elem); err != nil {
+		c.mu.Lock()
+		c.adjust(k, -1)
+		c.mu.Unlock()
+		return true, err
+	}
+	return true, nil
+}
+
+// Kill sends an element straight to the dead-letter queue, for the given
+// reason, as when it fails in a way that retrying cannot fix. If the push
+// fails, the counts are left as they were.
+func (c *CountedQueue[T, K]) Kill(elem T, reason string) error {
+
+	k := c.key(elem)
+
+	c.mu.Lock()
+	n := c.counts[k]
+	return c.bury(k, n, DeadLetter[T]{Elem: elem, Reason: reason, Requeues: n})
+}
+
+// bury sends an element that was requeued n times to the dead-letter queue,
+// and forgets its count, unless the push fails. It must be called with c.mu
+// held, which it releases before pushing, since the dead-letter queue may
+// block.
+func (c *CountedQueue[T, K]) bury(k K, n int, d DeadLetter[T]) error {
+
+	delete(c.counts, k)
+	c.deadSent++
+	c.mu.Unlock()
+
+	if err := c.dead.Push(d); err != nil {
+		c.mu.Lock()
+		c.deadSent--
+		c.adjust(k, n)
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// adjust adds delta to the count of key k, forgetting the count if it drops
+// to zero. It must be called with c.mu held.
+func (c *CountedQueue[T, K]) adjust(k K, delta int) {
+	if m := c.counts[k] + delta; m > 0 {
+		c.counts[k] = m
+	} else {
+		delete(c.counts, k)
+	}
+}
+
+// Done forgets the count of an element that has been processed.
+func (c *CountedQueue[T, K]) Done(elem T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, c.key(elem))
+}
+
+// Count returns the number of times an element has been requeued.
+func (c *CountedQueue[T, K]) Count(elem T) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[c.key(elem)]
+}
+
+// Counts returns the number of times each element that has been requeued, and
+// is neither done nor dead, has been requeued, by key.
+func (c *CountedQueue[T, K]) Counts() map[K]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[K]int, len(c.counts))
+	for k, n := range c.counts {
+		counts[k] = n
+	}
+	return counts
+}
+
+// DeadCount returns the number of elements sent to the dead-letter queue.
+func (c *CountedQueue[T, K]) DeadCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadSent
+}
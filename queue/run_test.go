@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type job struct {
+	id    int
+	fails int // number of attempts that fail
+}
+
+func TestCountedQueue(t *testing.T) {
+
+	var tests = map[string]struct {
+		limit     int
+		jobs      []job
+		expDone   []int
+		expDead   []DeadLetter[job]
+		expCounts map[int]int
+	}{
+		"no failures": {
+			limit:     2,
+			jobs:      []job{{id: 1}, {id: 2}},
+			expDone:   []int{1, 2},
+			expDead:   []DeadLetter[job]{},
+			expCounts: map[int]int{},
+		},
+		"failures within limit": {
+			limit:     2,
+			jobs:      []job{{id: 1, fails: 2}, {id: 2, fails: 1}},
+			expDone:   []int{2, 1},
+			expDead:   []DeadLetter[job]{},
+			expCounts: map[int]int{},
+		},
+		"failures over limit": {
+			limit:   2,
+			jobs:    []job{{id: 1, fails: 5}, {id: 2}},
+			expDone: []int{2},
+			expDead: []DeadLetter[job]{
+				{Elem: job{id: 1, fails: 5}, Reason: "requeue limit exceeded: attempt 3 failed", Requeues: 2},
+			},
+			expCounts: map[int]int{},
+		},
+		"no requeues allowed": {
+			limit:   0,
+			jobs:    []job{{id: 1, fails: 1}},
+			expDone: []int{},
+			expDead: []DeadLetter[job]{
+				{Elem: job{id: 1, fails: 1}, Reason: "requeue limit exceeded: attempt 1 failed", Requeues: 0},
+			},
+			expCounts: map[int]int{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dead := New[DeadLetter[job]](10)
+			q := NewCounted(New[job](10), dead, tt.limit, func(j job) int { return j.id })
+
+			for _, j := range tt.jobs {
+				assert.NoError(t, q.Push(j))
+			}
+
+			attempts := map[int]int{}
+			done := []int{}
+			for q.q.Len() > 0 {
+				j, _ := q.Pop()
+				attempts[j.id]++
+				if attempts[j.id] <= j.fails {
+					_, err := q.Requeue(j, "attempt "+string(rune('0'+attempts[j.id]))+" failed")
+					assert.NoError(t, err)
+					continue
+				}
+				q.Done(j)
+				done = append(done, j.id)
+			}
+
+			assert.Equal(t, tt.expDone, done)
+			assert.Equal(t, tt.expDead, dead.Drain())
+			assert.Equal(t, len(tt.expDead), q.DeadCount())
+			assert.Equal(t, tt.expCounts, q.Counts())
+		})
+	}
+}
+
+func TestCountedQueueInspect(t *testing.T) {
+
+	dead := New[DeadLetter[string]](1)
+	q := NewCounted(New[string](10), dead, 3, func(s string) string { return s })
+
+	q.Push("a")
+	q.Push("b")
+	ok, err := q.Requeue("a", "busy")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	q.Requeue("a", "busy")
+	q.Requeue("b", "busy")
+
+	assert.Equal(t, 2, q.Count("a"))
+	assert.Equal(t, 1, q.Count("b"))
+	assert.Equal(t, 0, q.Count("c"))
+	assert.Equal(t, map[string]int{"a": 2, "b": 1}, q.Counts())
+
+	assert.NoError(t, q.Kill("b", "malformed"))
+	assert.Equal(t, []DeadLetter[string]{{Elem: "b", Reason: "malformed", Requeues: 1}}, dead.Drain())
+	assert.Equal(t, map[string]int{"a": 2}, q.Counts())
+
+	dead.Close()
+	ok, err = q.Requeue("a", "busy")
+	assert.True(t, ok)
+	ok, err = q.Requeue("a", "busy")
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestCountedQueueFailedPush(t *testing.T) {
+
+	// a failed push leaves the counts as they were
+	inner := New[string](10)
+	dead := New[DeadLetter[string]](1)
+	q := NewCounted(inner, dead, 1, func(s string) string { return s })
+
+	q.Requeue("a", "busy")
+	q.Requeue("b", "busy")
+	dead.Close()
+
+	ok, err := q.Requeue("a", "busy")
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, q.Kill("c", "malformed"), ErrClosed)
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, q.Counts())
+	assert.Zero(t, q.DeadCount())
+
+	inner.Close()
+	q.Done("b")
+	ok, err = q.Requeue("b", "busy")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.Equal(t, map[string]int{"a": 1}, q.Counts())
+}
+
+func TestCountedQueueConcurrent(t *testing.T) {
+
+	const workers, items, limit = 4, 50, 3
+	dead := New[DeadLetter[int]](items)
+	q := NewCounted(New[int](items), dead, limit, func(i int) int { return i })
+	for i := 0; i < items; i++ {
+		q.Push(i)
+	}
+
+	// odd elements always fail; each worker stops once all elements are
+	// accounted for
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	finished := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i, ok := q.q.TryPop()
+				if !ok {
+					mu.Lock()
+					f := finished
+					mu.Unlock()
+					if f == items {
+						return
+					}
+					continue
+				}
+				if i%2 == 1 {
+					if requeued, _ := q.Requeue(i, "odd"); requeued {
+						continue
+					}
+				} else {
+					q.Done(i)
+				}
+				mu.Lock()
+				finished++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, items/2, q.DeadCount())
+	for _, d := range dead.Drain() {
+		assert.Equal(t, limit, d.Requeues)
+	}
+	assert.Empty(t, q.Counts())
+}
@@ -0,0 +1,95 @@
+package tree
+
+import "reflect"
+
+// Clone creates a deep copy of a tree. The copy has its own nodes and its
+// own index; no node is shared between the two trees, so either may be
+// modified or merged into another tree without affecting the other. The
+// order of children is preserved.
+//
+// The argument copyData is called on the data of every node to produce the
+// data of the corresponding node in the copy. If the data contains pointers,
+// maps or slices that should not be shared, copyData must copy them. If
+// copyData is nil, the data is copied by assignment.
+func (t *Tree[T]) Clone(copyData func(T) T) *Tree[T] {
+
+	c := Empty[T]()
+	if t.root == nil {
+		return c
+	}
+
+	if copyData == nil {
+		copyData = func(d T) T { return d }
+	}
+
+	c.root = c.cloneNode(t.root, nil, copyData)
+	return c
+}
+
+func (t *Tree[T]) cloneNode(n Node[T], parent Node[T], copyData func(T) T) Node[T] {
+
+	cn := &node[T]{primary: n.GetID(), parentID: n.GetParentID(), data: copyData(n.GetData()), owner: t}
+	cn.setParent(parent)
+	t.primary.insert(cn.primary, cn)
+
+	for _, child := range n.GetChildren() {
+		cn.AddChildren(t.cloneNode(child, cn, copyData))
+	}
+
+	return cn
+}
+
+// Equal reports whether two trees are structurally equal. Trees are equal if
+// they contain nodes with the same primary keys, every node has the same
+// parent in both trees and the data of every pair of matching nodes is equal
+// as determined by eq. If eq is nil, data is compared with reflect.DeepEqual.
+//
+// If ordered is true, the children of every node must also be in the same
+// order in both trees; otherwise, the order in which children were added is
+// ignored.
+func Equal[T any](a, b *Tree[T], eq func(T, T) bool, ordered bool) bool {
+
+	if a.root == nil || b.root == nil {
+		return a.root == nil && b.root == nil
+	}
+
+	if len(*a.primary) != len(*b.primary) {
+		return false
+	}
+
+	if eq == nil {
+		eq = func(x, y T) bool { return reflect.DeepEqual(x, y) }
+	}
+
+	for id, na := range *a.primary {
+
+		nb := b.primary.find(id)
+		if nb == nil {
+			return false
+		}
+
+		if na.GetParentID() != nb.GetParentID() {
+			return false
+		}
+		if (na.GetParent() == nil) != (nb.GetParent() == nil) {
+			return false
+		}
+		if !eq(na.GetData(), nb.GetData()) {
+			return false
+		}
+
+		ca, cb := na.GetChildren(), nb.GetChildren()
+		if len(ca) != len(cb) {
+			return false
+		}
+		if ordered {
+			for i := range ca {
+				if ca[i].GetID() != cb[i].GetID() {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,218 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClone(t *testing.T) {
+
+	type elem struct {
+		Name string
+		Tags []string
+	}
+
+	var tests = map[string]struct {
+		prep     func() *Tree[*elem]
+		copyData func(*elem) *elem
+		expBFC   []uint
+		expDFC   []uint
+		expShare bool
+	}{
+		"empty": {
+			prep:   Empty[*elem],
+			expBFC: []uint{},
+			expDFC: []uint{},
+		},
+		"copy data": {
+			prep: func() *Tree[*elem] {
+				t := Empty[*elem]()
+				t.Add(1, 0, &elem{"one", []string{"a"}})
+				t.Add(2, 1, &elem{"two", nil})
+				t.Add(3, 2, &elem{"three", nil})
+				t.Add(4, 1, &elem{"four", []string{"b", "c"}})
+				return t
+			},
+			copyData: func(e *elem) *elem {
+				c := *e
+				if e.Tags != nil {
+					c.Tags = append([]string{}, e.Tags...)
+				}
+				return &c
+			},
+			expBFC: []uint{1, 2, 4, 3},
+			expDFC: []uint{1, 2, 3, 4},
+		},
+		"assign data": {
+			prep: func() *Tree[*elem] {
+				t := Empty[*elem]()
+				t.Add(1, 0, &elem{"one", nil})
+				t.Add(2, 1, &elem{"two", nil})
+				return t
+			},
+			expBFC:   []uint{1, 2},
+			expDFC:   []uint{1, 2},
+			expShare: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			orig := tt.prep()
+			got := orig.Clone(tt.copyData)
+
+			assert.Equal(t, tt.expBFC, bfc([]Node[*elem]{got.root}, []uint{}))
+			assert.Equal(t, tt.expDFC, dfc(got.root, []uint{}))
+			assert.Len(t, *got.primary, len(tt.expBFC))
+			assert.True(t, Equal(orig, got, nil, true))
+
+			for _, key := range tt.expBFC {
+				o := orig.primary.find(key)
+				c := got.primary.find(key)
+				if assert.NotNil(t, c, "Expected value for %d not to be nil", key) {
+					assert.NotSame(t, o, c)
+					assert.Equal(t, o.GetParentID(), c.GetParentID())
+					if tt.expShare {
+						assert.Same(t, o.GetData(), c.GetData())
+					} else {
+						assert.NotSame(t, o.GetData(), c.GetData())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCloneIndependent(t *testing.T) {
+
+	orig := Empty[string]()
+	orig.Add(1, 0, "one")
+	orig.Add(2, 1, "two")
+
+	other := Empty[string]()
+	other.Add(3, 2, "three")
+
+	got := orig.Clone(nil)
+	assert.True(t, got.Merge(other.Clone(nil)))
+	got.Add(4, 1, "four")
+	n, _ := got.Find(1)
+	n.SetData("ONE")
+
+	assert.Equal(t, []uint{1, 2}, bfc([]Node[string]{orig.root}, []uint{}))
+	assert.Equal(t, []uint{1, 2, 4, 3}, bfc([]Node[string]{got.root}, []uint{}))
+	assert.Nil(t, other.root.GetParent())
+	assert.Equal(t, "one", orig.root.GetData())
+}
+
+func TestEqual(t *testing.T) {
+
+	base := func() *Tree[string] {
+		t := Empty[string]()
+		t.Add(1, 0, "one")
+		t.Add(2, 1, "two")
+		t.Add(3, 1, "three")
+		t.Add(4, 2, "four")
+		return t
+	}
+
+	var tests = map[string]struct {
+		a       func() *Tree[string]
+		b       func() *Tree[string]
+		eq      func(string, string) bool
+		ordered bool
+		exp     bool
+	}{
+		"both empty": {
+			a:   Empty[string],
+			b:   Empty[string],
+			exp: true,
+		},
+		"one empty": {
+			a:   base,
+			b:   Empty[string],
+			exp: false,
+		},
+		"same": {
+			a:       base,
+			b:       base,
+			ordered: true,
+			exp:     true,
+		},
+		"different ids": {
+			a: base,
+			b: func() *Tree[string] {
+				t := base()
+				t.Add(5, 3, "five")
+				return t
+			},
+			exp: false,
+		},
+		"different parentage": {
+			a: base,
+			b: func() *Tree[string] {
+				t := Empty[string]()
+				t.Add(1, 0, "one")
+				t.Add(2, 1, "two")
+				t.Add(3, 1, "three")
+				t.Add(4, 3, "four")
+				return t
+			},
+			exp: false,
+		},
+		"different data": {
+			a: base,
+			b: func() *Tree[string] {
+				t := base()
+				n, _ := t.Find(4)
+				n.SetData("FOUR")
+				return t
+			},
+			exp: false,
+		},
+		"custom data equality": {
+			a: base,
+			b: func() *Tree[string] {
+				t := base()
+				n, _ := t.Find(4)
+				n.SetData("FOUR")
+				return t
+			},
+			eq:  func(x, y string) bool { return len(x) == len(y) },
+			exp: true,
+		},
+		"child order ignored": {
+			a: base,
+			b: func() *Tree[string] {
+				t := Empty[string]()
+				t.Add(1, 0, "one")
+				t.Add(3, 1, "three")
+				t.Add(2, 1, "two")
+				t.Add(4, 2, "four")
+				return t
+			},
+			exp: true,
+		},
+		"child order matters": {
+			a: base,
+			b: func() *Tree[string] {
+				t := Empty[string]()
+				t.Add(1, 0, "one")
+				t.Add(3, 1, "three")
+				t.Add(2, 1, "two")
+				t.Add(4, 2, "four")
+				return t
+			},
+			ordered: true,
+			exp:     false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, b := tt.a(), tt.b()
+			assert.Equal(t, tt.exp, Equal(a, b, tt.eq, tt.ordered))
+			assert.Equal(t, tt.exp, Equal(b, a, tt.eq, tt.ordered))
+		})
+	}
+}
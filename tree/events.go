@@ -0,0 +1,212 @@
+package tree
+
+import "fmt"
+
+// EventType identifies the kind of change to a tree described by an Event.
+type EventType int
+
+const (
+	// NodeAdded is emitted when a node is inserted into the tree below an
+	// existing node, or as the first node of an empty tree. When a subtree is
+	// restored, for instance by undoing a removal, the event is emitted for
+	// the head of the subtree only. Reversing an addition that re-rooted the
+	// tree emits Removed for the added node, followed by NodeAdded for the
+	// former root, restored as the head of the tree.
+	NodeAdded EventType = iota
+	// Rerooted is emitted when a node is added as the parent of the root of
+	// the tree, becoming the new root.
+	Rerooted
+	// Merged is emitted when another tree is merged into the tree. The node of
+	// the event is the head of the merged tree.
+	Merged
+	// DataChanged is emitted when the data of a node is replaced, whether
+	// through the tree or through the Node interface.
+	DataChanged
+	// Removed is emitted when a node, along with its descendents, is removed
+	// from the tree. The event is emitted for the head of the removed subtree
+	// only; its descendents remain reachable through GetChildren.
+	Removed
+	// Moved is emitted when a node is given a new parent.
+	Moved
+)
+
+var eventNames = map[EventType]string{
+	NodeAdded:   "NodeAdded",
+	Rerooted:    "Rerooted",
+	Merged:      "Merged",
+	DataChanged: "DataChanged",
+	Removed:     "Removed",
+	Moved:       "Moved",
+}
+
+// String returns the name of the event type.
+func (e EventType) String() string {
+	if name, ok := eventNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(e))
+}
+
+// Event describes a single change to a tree.
+type Event[T any] struct {
+	Type EventType
+	// Node is the node that was changed. Its fields reflect the state of the
+	// tree after the change.
+	Node Node[T]
+	// OldParentID is the primary key of the former parent of the node for
+	// Moved and Removed events.
+	OldParentID uint
+	// OldData is the data held by the node before a DataChanged event.
+	OldData T
+}
+
+// observers holds the subscriptions to the events of a tree.
+type observers[T any] struct {
+	next int
+	subs []subscription[T]
+
+	// while holding, events are queued rather than delivered
+	holding bool
+	held    []Event[T]
+
+	// watchers receive every event as soon as it is emitted, even while
+	// holding, including events for changes that are later rolled back
+	watchers []subscription[T]
+}
+
+type subscription[T any] struct {
+	id int
+	f  func(Event[T])
+}
+
+// Subscribe registers a function that is called with every event emitted by
+// the tree, in the order the changes are made. The returned function cancels
+// the subscription.
+//
+// Events are delivered synchronously, from inside the call that changed the
+// tree, so f must not modify the tree itself. For a SyncTree, f is called
+// while the write lock is held and must not call any method of the SyncTree.
+//
+// Changes made by Tree.Apply and Tree.Batch are delivered only once the whole
+// patch or transaction has succeeded; one that fails emits no events.
+func (t *Tree[T]) Subscribe(f func(Event[T])) (cancel func()) {
+
+	if t.observers == nil {
+		t.observers = &observers[T]{}
+	}
+	o := t.observers
+
+	id := o.next
+	o.next++
+	o.subs = append(o.subs, subscription[T]{id: id, f: f})
+
+	return func() {
+		o.subs = unsubscribe(o.subs, id)
+	}
+}
+
+// watch registers a function that is called with every event as soon as it
+// is emitted. Unlike subscribers, watchers are not affected by holds: they see
+// the changes of a failed patch or transaction, followed by the changes that
+// reverse them. The events received by a watcher are therefore always in step
+// with the state of the tree.
+func (t *Tree[T]) watch(f func(Event[T])) (cancel func()) {
+
+	if t.observers == nil {
+		t.observers = &observers[T]{}
+	}
+	o := t.observers
+
+	id := o.next
+	o.next++
+	o.watchers = append(o.watchers, subscription[T]{id: id, f: f})
+
+	return func() {
+		o.watchers = unsubscribe(o.watchers, id)
+	}
+}
+
+func unsubscribe[T any](subs []subscription[T], id int) []subscription[T] {
+	for i, s := range subs {
+		if s.id == id {
+			return append(subs[:i:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// SubscribeChan registers a channel to which every event emitted by the tree
+// is sent. The returned function cancels the subscription; the channel is
+// never closed by the tree.
+//
+// Events are sent synchronously, as for Subscribe, so a change to the tree
+// blocks until its events are received. The channel should be buffered, or
+// consumed by another goroutine that does not itself use the tree.
+func (t *Tree[T]) SubscribeChan(ch chan<- Event[T]) (cancel func()) {
+	return t.Subscribe(func(e Event[T]) {
+		ch <- e
+	})
+}
+
+// emit delivers an event to all subscribers, or queues it if events are being
+// held.
+func (t *Tree[T]) emit(e Event[T]) {
+
+	o := t.observers
+	if o == nil {
+		return
+	}
+
+	for _, w := range o.watchers {
+		w.f(e)
+	}
+
+	if len(o.subs) == 0 {
+		return
+	}
+
+	if o.holding {
+		o.held = append(o.held, e)
+		return
+	}
+
+	for _, s := range o.subs {
+		s.f(e)
+	}
+}
+
+// hold queues all events emitted by the tree until release is called. The
+// returned function ends the hold; if deliver is true, the queued events are
+// delivered, otherwise they are discarded. Holds may nest: the events of an
+// inner hold are discarded if it is released without delivery, and otherwise
+// remain queued until the outermost hold is released.
+func (t *Tree[T]) hold() (release func(deliver bool)) {
+
+	if t.observers == nil {
+		t.observers = &observers[T]{}
+	}
+	o := t.observers
+
+	if o.holding {
+		mark := len(o.held)
+		return func(deliver bool) {
+			if !deliver {
+				o.held = o.held[:mark]
+			}
+		}
+	}
+	o.holding = true
+
+	return func(deliver bool) {
+		held := o.held
+		o.holding = false
+		o.held = nil
+		if deliver {
+			for _, e := range held {
+				for _, s := range o.subs {
+					s.f(e)
+				}
+			}
+		}
+	}
+}
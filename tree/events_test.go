@@ -0,0 +1,201 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type gotEvent struct {
+	Type        EventType
+	ID          uint
+	OldParentID uint
+	OldData     string
+}
+
+func recordEvents(tree *Tree[string]) (*[]gotEvent, func()) {
+	got := &[]gotEvent{}
+	cancel := tree.Subscribe(func(e Event[string]) {
+		*got = append(*got, gotEvent{e.Type, e.Node.GetID(), e.OldParentID, e.OldData})
+	})
+	return got, cancel
+}
+
+func TestEvents(t *testing.T) {
+
+	var tests = map[string]struct {
+		prep      func() *Tree[string]
+		change    func(*Tree[string])
+		expEvents []gotEvent
+	}{
+		"add": {
+			prep: Empty[string],
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+				t.Add(2, 1, "two")
+				t.Add(2, 1, "two")
+				t.Add(4, 3, "four")
+			},
+			expEvents: []gotEvent{
+				{Type: NodeAdded, ID: 1},
+				{Type: NodeAdded, ID: 2},
+			},
+		},
+		"re-root": {
+			prep: func() *Tree[string] {
+				t := Empty[string]()
+				t.Add(1, 2, "one")
+				return t
+			},
+			change: func(t *Tree[string]) {
+				t.Add(2, 0, "two")
+			},
+			expEvents: []gotEvent{
+				{Type: Rerooted, ID: 2},
+			},
+		},
+		"merge": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				other := Empty[string]()
+				other.Add(6, 5, "six")
+				other.Add(7, 6, "seven")
+				t.Merge(other)
+			},
+			expEvents: []gotEvent{
+				{Type: Merged, ID: 6},
+			},
+		},
+		"set data through node": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				n, _ := t.Find(3)
+				n.SetData("THREE")
+			},
+			expEvents: []gotEvent{
+				{Type: DataChanged, ID: 3, OldData: "three"},
+			},
+		},
+		"set data on merged node": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				other := Empty[string]()
+				other.Add(6, 5, "six")
+				t.Merge(other)
+				n, _ := t.Find(6)
+				n.SetData("SIX")
+			},
+			expEvents: []gotEvent{
+				{Type: Merged, ID: 6},
+				{Type: DataChanged, ID: 6, OldData: "six"},
+			},
+		},
+		"patch": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{
+					{Type: OpAdd, ID: 6, ParentID: 5, Data: "six"},
+					{Type: OpMove, ID: 3, ParentID: 6},
+					{Type: OpRemove, ID: 2},
+					{Type: OpSetData, ID: 3, Data: "THREE"},
+				})
+			},
+			expEvents: []gotEvent{
+				{Type: NodeAdded, ID: 6},
+				{Type: Moved, ID: 3, OldParentID: 2},
+				{Type: Removed, ID: 2, OldParentID: 1},
+				{Type: DataChanged, ID: 3, OldData: "three"},
+			},
+		},
+		"failed patch": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{
+					{Type: OpAdd, ID: 6, ParentID: 5, Data: "six"},
+					{Type: OpRemove, ID: 9},
+				})
+			},
+			expEvents: []gotEvent{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := tt.prep()
+			got, _ := recordEvents(tree)
+
+			tt.change(tree)
+
+			assert.Equal(t, tt.expEvents, *got)
+		})
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+
+	tree := Empty[string]()
+	first, cancelFirst := recordEvents(tree)
+	second, _ := recordEvents(tree)
+
+	tree.Add(1, 0, "one")
+	cancelFirst()
+	tree.Add(2, 1, "two")
+
+	assert.Equal(t, []gotEvent{{Type: NodeAdded, ID: 1}}, *first)
+	assert.Equal(t, []gotEvent{{Type: NodeAdded, ID: 1}, {Type: NodeAdded, ID: 2}}, *second)
+}
+
+func TestEventsRemovedNode(t *testing.T) {
+
+	tree := Empty[string]()
+	tree.Add(1, 0, "one")
+	tree.Add(2, 1, "two")
+	tree.EnableHistory(10)
+	n, _ := tree.Find(2)
+	require.NoError(t, tree.Apply(Patch[string]{{Type: OpRemove, ID: 2}}))
+
+	// a node no longer in the tree changes silently, without an undo step
+	got, _ := recordEvents(tree)
+	n.SetData("changed")
+	assert.Empty(t, *got)
+
+	// undoing the removal puts the node back, after which it emits again
+	assert.True(t, tree.Undo())
+	assert.False(t, tree.Undo())
+	n.SetData("again")
+	assert.Equal(t, []gotEvent{
+		{Type: NodeAdded, ID: 2},
+		{Type: DataChanged, ID: 2, OldData: "changed"},
+	}, *got)
+}
+
+func TestSubscribeChan(t *testing.T) {
+
+	tree := Empty[int]()
+	ch := make(chan Event[int], 2)
+	cancel := tree.SubscribeChan(ch)
+
+	tree.Add(1, 0, 1)
+	tree.Add(2, 1, 2)
+	cancel()
+	tree.Add(3, 1, 3)
+
+	assert.Len(t, ch, 2)
+	assert.Equal(t, uint(1), (<-ch).Node.GetID())
+	assert.Equal(t, uint(2), (<-ch).Node.GetID())
+}
+
+func TestSyncTreeSubscribe(t *testing.T) {
+
+	s := NewSync[int](nil)
+	got := []EventType{}
+	cancel := s.Subscribe(func(e Event[int]) { got = append(got, e.Type) })
+
+	s.Add(1, 0, 1)
+	s.SetData(1, 2)
+	cancel()
+	s.Add(2, 1, 2)
+
+	assert.Equal(t, []EventType{NodeAdded, DataChanged}, got)
+}
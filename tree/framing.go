@@ -0,0 +1,393 @@
+package tree
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+var (
+	// ErrTruncated indicates that a serialized tree ends before its trailer,
+	// as when a file was only partly written or copied.
+	ErrTruncated = errors.New("serialized tree is truncated")
+	// ErrCorrupt indicates that a serialized tree does not match its trailer,
+	// or cannot be decompressed.
+	ErrCorrupt = errors.New("serialized tree is corrupt")
+)
+
+// ChecksumType selects the digest written in the trailer of a serialized tree.
+type ChecksumType string
+
+const (
+	// NoChecksum writes no trailer.
+	NoChecksum ChecksumType = ""
+	// ChecksumCRC32 writes an IEEE CRC-32 digest.
+	ChecksumCRC32 ChecksumType = "crc32"
+	// ChecksumSHA256 writes a SHA-256 digest.
+	ChecksumSHA256 ChecksumType = "sha256"
+)
+
+// SerializeOptions configures the framing of a serialized tree.
+type SerializeOptions struct {
+	// Schema is the schema version written in the header. If zero, the version
+	// registered for the node data type with RegisterSchema is written.
+	Schema int
+	// Gzip compresses the whole stream with gzip.
+	Gzip bool
+	// Checksum, if set, adds a trailer line after the nodes. The trailer holds
+	// the number of nodes and a digest of all lines before it, so that
+	// Deserialize can tell a complete stream from a truncated or corrupted one.
+	Checksum ChecksumType
+}
+
+// serialTrailer is the last line of a stream written with a checksum.
+type serialTrailer struct {
+	Nodes  int
+	Digest string
+}
+
+// rawLine decodes any line of a serialized tree: a node, the header or the
+// trailer. The data of a node is decoded once the schema of the stream is
+// known.
+type rawLine struct {
+	Primary  uint
+	ParentID uint
+	Data     json.RawMessage
+	Header   *Header        `json:",omitempty"`
+	Trailer  *serialTrailer `json:",omitempty"`
+}
+
+func newDigest(c ChecksumType) (hash.Hash, error) {
+	switch c {
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum %q: %w", c, ErrFormat)
+}
+
+// SerializeWith encodes the tree as a byte stream, like Serialize, with the
+// framing selected by opts. The stream starts with a Header, which records the
+// schema version of the node data; see RegisterSchema. Deserialize detects the
+// framing by itself.
+func (t *Tree[T]) SerializeWith(trvsl TraversalType, opts SerializeOptions) (io.ReadCloser, <-chan error) {
+	return t.serialize(trvsl, opts, true)
+}
+
+// serialize writes the stream of Serialize, or of SerializeWith if framed is
+// set, from a goroutine.
+func (t *Tree[T]) serialize(trvsl TraversalType, opts SerializeOptions, framed bool) (io.ReadCloser, <-chan error) {
+	reader, writer := io.Pipe()
+	errchan := make(chan error, 1)
+
+	go func() {
+		err := t.writeFramed(writer, trvsl, opts, framed)
+		if err != nil {
+			errchan <- err
+		}
+		close(errchan)
+		writer.CloseWithError(err)
+	}()
+
+	return reader, errchan
+}
+
+func (t *Tree[T]) writeFramed(w io.Writer, trvsl TraversalType, opts SerializeOptions, framed bool) (err error) {
+
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	var digest hash.Hash
+	if opts.Checksum != NoChecksum {
+		if digest, err = newDigest(opts.Checksum); err != nil {
+			return err
+		}
+	}
+
+	line := func(v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+		if digest != nil {
+			digest.Write(b)
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	if framed {
+		header := Header{
+			Format:   FormatVersion,
+			Schema:   opts.Schema,
+			Created:  time.Now().UTC(),
+			Nodes:    len(*t.primary),
+			Checksum: opts.Checksum,
+		}
+		if header.Schema == 0 {
+			header.Schema, _, _ = schemaOf[T]()
+		}
+		if err := line(struct{ Header Header }{header}); err != nil {
+			return err
+		}
+	}
+
+	// the traversal is drained on error, so that its goroutine ends
+	nodes := 0
+	for n := range t.Traverse(trvsl) {
+		if err != nil {
+			continue
+		}
+		err = line(serialNode[T]{
+			Primary:  n.GetID(),
+			ParentID: n.GetParentID(),
+			Data:     n.GetData(),
+		})
+		nodes++
+	}
+	if err != nil {
+		return err
+	}
+
+	if digest != nil {
+		trailer := serialTrailer{Nodes: nodes, Digest: hex.EncodeToString(digest.Sum(nil))}
+		digest = nil
+		if err := line(struct{ Trailer serialTrailer }{trailer}); err != nil {
+			return err
+		}
+	}
+
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+// frameReader reads the lines of a serialized tree, decompressing it if
+// needed, migrating node data from older schema versions, and verifying its
+// header and trailer.
+type frameReader[T any] struct {
+	lines   *bufio.Reader
+	header  *Header
+	digest  hash.Hash
+	nodes   int
+	trailed bool
+
+	schema     int // the current version, registered for T
+	migrate    Migration[T]
+	registered bool
+	headerless int // the version of a stream without a header
+}
+
+func newFrameReader[T any](stream io.Reader) (*frameReader[T], error) {
+
+	f := &frameReader[T]{}
+	f.schema, f.migrate, f.registered = schemaOf[T]()
+
+	buffered := bufio.NewReader(stream)
+	f.lines = buffered
+
+	// a gzip stream starts with bytes that cannot start a JSON value
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, streamError(err)
+		}
+		f.lines = bufio.NewReader(gz)
+	}
+
+	return f, nil
+}
+
+// line reads the next line of the stream that is not blank. It returns io.EOF
+// at the end of the stream.
+func (f *frameReader[T]) line() ([]byte, rawLine, error) {
+
+	for {
+		line, err := f.lines.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, rawLine{}, streamError(err)
+		}
+		end := err == io.EOF
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			if end {
+				return nil, rawLine{}, io.EOF
+			}
+			continue
+		}
+
+		var l rawLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			if end && f.header != nil {
+				return nil, rawLine{}, fmt.Errorf("partial last line: %w", ErrTruncated)
+			}
+			return nil, rawLine{}, err
+		}
+		return line, l, nil
+	}
+}
+
+// readHeader reads the first line of the stream, which is its header if it
+// has one.
+func (f *frameReader[T]) readHeader() (Header, error) {
+	_, l, err := f.line()
+	if err == io.EOF || (err == nil && l.Header == nil) {
+		return Header{}, nil
+	}
+	if err != nil {
+		return Header{}, err
+	}
+	return *l.Header, nil
+}
+
+// next returns the next node of the stream, or io.EOF after the last node.
+func (f *frameReader[T]) next() (serialNode[T], error) {
+
+	for {
+		line, l, err := f.line()
+		if err == io.EOF {
+			return serialNode[T]{}, f.finish()
+		}
+		if err != nil {
+			return serialNode[T]{}, err
+		}
+		if f.trailed {
+			return serialNode[T]{}, fmt.Errorf("data after trailer: %w", ErrCorrupt)
+		}
+
+		switch {
+		case l.Header != nil:
+			if f.header != nil || f.nodes > 0 {
+				return serialNode[T]{}, fmt.Errorf("misplaced header: %w", ErrCorrupt)
+			}
+			if err := f.readFrom(l.Header); err != nil {
+				return serialNode[T]{}, err
+			}
+			if f.digest != nil {
+				f.digest.Write(line)
+			}
+
+		case l.Trailer != nil:
+			if err := f.verify(l.Trailer); err != nil {
+				return serialNode[T]{}, err
+			}
+			f.trailed = true
+
+		default:
+			if f.digest != nil {
+				f.digest.Write(line)
+			}
+			f.nodes++
+			return f.decode(l)
+		}
+	}
+}
+
+// readFrom checks that the stream described by a header can be read.
+func (f *frameReader[T]) readFrom(h *Header) (err error) {
+
+	if h.Format > FormatVersion {
+		return fmt.Errorf("format version %d is newer than %d: %w", h.Format, FormatVersion, ErrFormat)
+	}
+	if f.registered && h.Schema > f.schema {
+		return fmt.Errorf("schema version %d is newer than %d: %w", h.Schema, f.schema, ErrFormat)
+	}
+	if h.Checksum != NoChecksum {
+		if f.digest, err = newDigest(h.Checksum); err != nil {
+			return err
+		}
+	}
+
+	f.header = h
+	return nil
+}
+
+// decode decodes the data of a node, migrating it if it was written with an
+// older schema version.
+func (f *frameReader[T]) decode(l rawLine) (serialNode[T], error) {
+
+	n := serialNode[T]{Primary: l.Primary, ParentID: l.ParentID}
+
+	from := f.headerless
+	if f.header != nil {
+		from = f.header.Schema
+	}
+
+	if f.migrate != nil && from < f.schema {
+		data, err := f.migrate(l.Data, from)
+		if err != nil {
+			return n, fmt.Errorf("error migrating node %d from schema version %d: %w", l.Primary, from, err)
+		}
+		n.Data = data
+		return n, nil
+	}
+
+	if len(l.Data) > 0 {
+		if err := json.Unmarshal(l.Data, &n.Data); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (f *frameReader[T]) verify(trailer *serialTrailer) error {
+	if trailer.Nodes != f.nodes {
+		return fmt.Errorf("trailer counts %d nodes, found %d: %w", trailer.Nodes, f.nodes, ErrCorrupt)
+	}
+	if f.digest == nil {
+		return fmt.Errorf("trailer without checksum: %w", ErrCorrupt)
+	}
+	if sum := hex.EncodeToString(f.digest.Sum(nil)); sum != trailer.Digest {
+		return fmt.Errorf("digest mismatch: %w", ErrCorrupt)
+	}
+	return nil
+}
+
+// finish checks, at the end of the stream, that it holds the nodes counted in
+// its header and, if it has a checksum, that it had its trailer.
+func (f *frameReader[T]) finish() error {
+	if f.header == nil {
+		return io.EOF
+	}
+	if f.header.Checksum != NoChecksum && !f.trailed {
+		return fmt.Errorf("missing trailer after %d nodes: %w", f.nodes, ErrTruncated)
+	}
+	if f.header.Format >= 1 && f.nodes < f.header.Nodes {
+		return fmt.Errorf("header counts %d nodes, found %d: %w", f.header.Nodes, f.nodes, ErrTruncated)
+	}
+	if f.header.Format >= 1 && f.nodes > f.header.Nodes {
+		return fmt.Errorf("header counts %d nodes, found %d: %w", f.header.Nodes, f.nodes, ErrCorrupt)
+	}
+	return io.EOF
+}
+
+// streamError classifies errors reading a stream, which come from gzip when
+// the stream is compressed.
+func streamError(err error) error {
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.As(err, &corrupt):
+		return fmt.Errorf("%v: %w", err, ErrCorrupt)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%v: %w", err, ErrTruncated)
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader):
+		return fmt.Errorf("%v: %w", err, ErrCorrupt)
+	}
+	return err
+}
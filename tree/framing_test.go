@@ -0,0 +1,99 @@
+package tree
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serializeFramed(t *testing.T, tree *Tree[string], opts SerializeOptions) []byte {
+	rdr, errchan := tree.SerializeWith(TraverseBreadthFirst, opts)
+	data, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, <-errchan)
+	return data
+}
+
+func TestSerializeWith(t *testing.T) {
+
+	var tests = map[string]SerializeOptions{
+		"plain":           {},
+		"gzip":            {Gzip: true},
+		"crc32":           {Checksum: ChecksumCRC32},
+		"sha256":          {Checksum: ChecksumSHA256},
+		"gzip and sha256": {Gzip: true, Checksum: ChecksumSHA256},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, tree := range []*Tree[string]{patchTestTree(), Empty[string]()} {
+				data := serializeFramed(t, tree, opts)
+				got, err := Deserialize[string](io.NopCloser(bytes.NewReader(data)))
+				require.NoError(t, err)
+				assert.True(t, Equal(tree, got, nil, true))
+			}
+		})
+	}
+}
+
+func TestSerializeWithUnknownChecksum(t *testing.T) {
+	rdr, errchan := patchTestTree().SerializeWith(TraverseBreadthFirst, SerializeOptions{Checksum: "md5"})
+	_, err := io.ReadAll(rdr)
+	assert.ErrorIs(t, err, ErrFormat)
+	assert.ErrorIs(t, <-errchan, ErrFormat)
+}
+
+func TestDeserializeDamaged(t *testing.T) {
+
+	checksummed := serializeFramed(t, patchTestTree(), SerializeOptions{Checksum: ChecksumCRC32})
+	compressed := serializeFramed(t, patchTestTree(), SerializeOptions{Gzip: true, Checksum: ChecksumCRC32})
+	lines := bytes.SplitAfter(checksummed, []byte("\n"))
+
+	var tests = map[string]struct {
+		data   []byte
+		expErr error
+	}{
+		"missing trailer": {
+			data:   bytes.Join(lines[:len(lines)-2], nil),
+			expErr: ErrTruncated,
+		},
+		"partial line": {
+			data:   checksummed[:len(checksummed)/2],
+			expErr: ErrTruncated,
+		},
+		"header only": {
+			data:   lines[0],
+			expErr: ErrTruncated,
+		},
+		"changed data": {
+			data:   bytes.Replace(checksummed, []byte(`"three"`), []byte(`"thr3e"`), 1),
+			expErr: ErrCorrupt,
+		},
+		"missing node": {
+			data:   bytes.Join(append(append([][]byte{}, lines[:2]...), lines[3:]...), nil),
+			expErr: ErrCorrupt,
+		},
+		"data after trailer": {
+			data:   append(append([]byte{}, checksummed...), lines[1]...),
+			expErr: ErrCorrupt,
+		},
+		"truncated gzip": {
+			data:   compressed[:len(compressed)/2],
+			expErr: ErrTruncated,
+		},
+		"corrupt gzip checksum": {
+			data:   append(append([]byte{}, compressed[:len(compressed)-8]...), 0, 0, 0, 0, 0, 0, 0, 0),
+			expErr: ErrCorrupt,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Deserialize[string](io.NopCloser(bytes.NewReader(tt.data)))
+			assert.ErrorIs(t, err, tt.expErr)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package tree
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathID returns the primary key given by FromFS to the entry at a path. The
+// key depends only on the path, so that an entry has the same key every time
+// a filesystem is read, whatever other entries are added or removed.
+//
+// The key is a 64-bit hash of the path. Where uint is 32 bits wide, the two
+// halves of the hash are folded together, so that every bit of it still
+// counts; collisions between paths are then more likely.
+func PathID(p string) uint {
+	h := fnv.New64a()
+	h.Write([]byte(path.Clean(p)))
+	sum := h.Sum64()
+	if ^uint(0)>>32 == 0 {
+		sum ^= sum >> 32
+	}
+	id := uint(sum)
+	if id == 0 { // zero is reserved for the parent of the root
+		id = 1
+	}
+	return id
+}
+
+// FromFS builds a tree from the entries of a filesystem, from the directory or
+// file at root down. Every entry becomes a node, with its parent directory as
+// the parent of the node, and with primary key PathID(path). The data of each
+// node is returned by f, which is called with the path of the entry in fsys,
+// as by fs.WalkDir.
+//
+// If f returns fs.SkipDir, the entry is skipped, along with all of its
+// contents if it is a directory; if it returns any other error, FromFS stops
+// and returns the error. Skipping root yields an empty tree.
+//
+// Children are added in lexical order of their names.
+func FromFS[T any](fsys fs.FS, root string, f func(path string, d fs.DirEntry) (T, error)) (*Tree[T], error) {
+
+	t := Empty[T]()
+
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		data, err := f(p, d)
+		if errors.Is(err, fs.SkipDir) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil // skip the file only, not the rest of its directory
+		}
+		if err != nil {
+			return err
+		}
+
+		var parentID uint
+		if p != root {
+			parentID = PathID(path.Dir(p))
+		}
+		if added, exists := t.Add(PathID(p), parentID, data); !added {
+			if exists {
+				return fmt.Errorf("path %q: key %d: %w", p, PathID(p), ErrExists)
+			}
+			return fmt.Errorf("path %q: parent: %w", p, ErrNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading tree from filesystem: %w", err)
+	}
+
+	return t, nil
+}
+
+// FSEntry describes a file or directory written by ToFS.
+type FSEntry struct {
+	// Name is the name of the entry within its directory. It must be a single
+	// path element. The name of the root is ignored.
+	Name string
+	// Dir makes the entry a directory. Nodes with children must be
+	// directories.
+	Dir bool
+	// Data is the content of a file.
+	Data []byte
+	// Mode holds the permission bits of the entry. If zero, directories are
+	// created with 0755 and files with 0644, before the umask.
+	Mode fs.FileMode
+}
+
+// ToFS writes the tree out as directories and files below dir, the reverse of
+// FromFS. The root of the tree is dir itself, which is created if it does not
+// exist; every other node becomes an entry in the directory of its parent,
+// described by f.
+//
+// If f returns fs.SkipDir, the node is skipped along with its descendents; if
+// it returns any other error, ToFS stops and returns the error. Entries are
+// never overwritten: if two siblings have the same name, or an entry already
+// exists, the error wraps fs.ErrExist.
+func (t *Tree[T]) ToFS(dir string, f func(n Node[T]) (FSEntry, error)) error {
+
+	if t.root == nil {
+		return nil
+	}
+
+	var write func(n Node[T], p string, root bool) error
+	write = func(n Node[T], p string, root bool) error {
+
+		e, err := f(n)
+		if errors.Is(err, fs.SkipDir) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !root {
+			if e.Name == "" || e.Name == "." || e.Name == ".." || strings.ContainsAny(e.Name, `/\`) {
+				return fmt.Errorf("node %d: invalid name %q", n.GetID(), e.Name)
+			}
+			p = filepath.Join(p, e.Name)
+		}
+
+		children := n.GetChildren()
+		if !e.Dir {
+			if root || len(children) > 0 {
+				return fmt.Errorf("node %d: %q must be a directory", n.GetID(), p)
+			}
+			return writeFile(p, e)
+		}
+
+		mode := e.Mode.Perm()
+		if mode == 0 {
+			mode = 0o755
+		}
+		if root {
+			err = os.MkdirAll(p, mode)
+		} else {
+			err = os.Mkdir(p, mode)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, c := range children {
+			if err := write(c, p, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := write(t.root, dir, true); err != nil {
+		return fmt.Errorf("error writing tree to filesystem: %w", err)
+	}
+	return nil
+}
+
+func writeFile(p string, e FSEntry) error {
+
+	mode := e.Mode.Perm()
+	if mode == 0 {
+		mode = 0o644
+	}
+
+	file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(e.Data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
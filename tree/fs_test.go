@@ -0,0 +1,202 @@
+package tree
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fsTestEntry struct {
+	Name string
+	Dir  bool
+	Data string
+}
+
+func fsTestData(fsys fs.FS) func(string, fs.DirEntry) (fsTestEntry, error) {
+	return func(p string, d fs.DirEntry) (fsTestEntry, error) {
+		if d.Name() == "skip" || path.Ext(p) == ".tmp" {
+			return fsTestEntry{}, fs.SkipDir
+		}
+		e := fsTestEntry{Name: d.Name(), Dir: d.IsDir()}
+		if !d.IsDir() {
+			data, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return e, err
+			}
+			e.Data = string(data)
+		}
+		return e, nil
+	}
+}
+
+func fsTestMap() fstest.MapFS {
+	return fstest.MapFS{
+		"src/main.go":      {Data: []byte("package main")},
+		"src/lib/a.go":     {Data: []byte("package lib // a")},
+		"src/lib/b.go":     {Data: []byte("package lib // b")},
+		"src/lib/b.go.tmp": {Data: []byte("partial")},
+		"src/skip/ignored": {Data: []byte("ignored")},
+		"src/empty":        {Mode: fs.ModeDir},
+		"README.md":        {Data: []byte("outside the root")},
+	}
+}
+
+func TestFromFS(t *testing.T) {
+
+	fsys := fsTestMap()
+
+	var tests = map[string]struct {
+		root     string
+		expPaths []string
+		expErr   bool
+	}{
+		"directory": {
+			root:     "src",
+			expPaths: []string{"src", "src/empty", "src/lib", "src/lib/a.go", "src/lib/b.go", "src/main.go"},
+		},
+		"subdirectory": {
+			root:     "src/lib",
+			expPaths: []string{"src/lib", "src/lib/a.go", "src/lib/b.go"},
+		},
+		"file": {
+			root:     "README.md",
+			expPaths: []string{"README.md"},
+		},
+		"skipped root": {
+			root:     "src/skip",
+			expPaths: []string{},
+		},
+		"missing": {
+			root:   "nothing",
+			expErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree, err := FromFS(fsys, tt.root, fsTestData(fsys))
+			if tt.expErr {
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+
+			expIDs := []uint{}
+			for _, p := range tt.expPaths {
+				expIDs = append(expIDs, PathID(p))
+			}
+			assert.Equal(t, expIDs, dfc(tree.root, []uint{}))
+		})
+	}
+
+	tree, err := FromFS(fsys, "src", fsTestData(fsys))
+	require.NoError(t, err)
+	n, ok := tree.Find(PathID("src/lib/b.go"))
+	require.True(t, ok)
+	assert.Equal(t, fsTestEntry{Name: "b.go", Data: "package lib // b"}, n.GetData())
+	assert.Equal(t, PathID("src/lib"), n.GetParentID())
+}
+
+func TestFromFSStableIDs(t *testing.T) {
+
+	fsys := fsTestMap()
+	before, err := FromFS(fsys, "src", fsTestData(fsys))
+	require.NoError(t, err)
+
+	// adding an entry does not change the keys of the others
+	fsys["src/lib/0.go"] = &fstest.MapFile{Data: []byte("package lib // 0")}
+	after, err := FromFS(fsys, "src", fsTestData(fsys))
+	require.NoError(t, err)
+
+	for id := range *before.primary {
+		_, ok := after.Find(id)
+		assert.True(t, ok)
+	}
+	assert.Len(t, *after.primary, len(*before.primary)+1)
+}
+
+func TestFromFSError(t *testing.T) {
+	fsys := fsTestMap()
+	abort := errors.New("abort")
+	_, err := FromFS(fsys, "src", func(p string, d fs.DirEntry) (int, error) {
+		if p == "src/lib" {
+			return 0, abort
+		}
+		return 0, nil
+	})
+	assert.ErrorIs(t, err, abort)
+}
+
+func fsTestEntries(n Node[fsTestEntry]) (FSEntry, error) {
+	d := n.GetData()
+	return FSEntry{Name: d.Name, Dir: d.Dir, Data: []byte(d.Data)}, nil
+}
+
+func TestToFS(t *testing.T) {
+
+	fsys := fsTestMap()
+	tree, err := FromFS(fsys, "src", fsTestData(fsys))
+	require.NoError(t, err)
+
+	base := t.TempDir()
+	dir := filepath.Join(base, "src")
+	require.NoError(t, tree.ToFS(dir, fsTestEntries))
+
+	data, err := os.ReadFile(filepath.Join(dir, "lib", "a.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package lib // a", string(data))
+
+	// reading the written files back at the same path yields the same tree
+	out := os.DirFS(base)
+	got, err := FromFS(out, "src", fsTestData(out))
+	require.NoError(t, err)
+	assert.True(t, Equal(tree, got, nil, true))
+
+	// existing entries are not overwritten
+	assert.ErrorIs(t, tree.ToFS(dir, fsTestEntries), fs.ErrExist)
+}
+
+func TestToFSInvalid(t *testing.T) {
+
+	var tests = map[string]struct {
+		prep func() *Tree[fsTestEntry]
+	}{
+		"file with children": {
+			prep: func() *Tree[fsTestEntry] {
+				t := Empty[fsTestEntry]()
+				t.Add(1, 0, fsTestEntry{Dir: true})
+				t.Add(2, 1, fsTestEntry{Name: "file"})
+				t.Add(3, 2, fsTestEntry{Name: "child"})
+				return t
+			},
+		},
+		"file as root": {
+			prep: func() *Tree[fsTestEntry] {
+				t := Empty[fsTestEntry]()
+				t.Add(1, 0, fsTestEntry{Name: "file"})
+				return t
+			},
+		},
+		"name with separator": {
+			prep: func() *Tree[fsTestEntry] {
+				t := Empty[fsTestEntry]()
+				t.Add(1, 0, fsTestEntry{Dir: true})
+				t.Add(2, 1, fsTestEntry{Name: "../escape"})
+				return t
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, tt.prep().ToFS(t.TempDir(), fsTestEntries))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package tree
+
+// history is a journal of the mutations of a tree, recording for every
+// mutation a function that reverses it and a function that performs it again.
+type history struct {
+	depth int
+	undo  []step
+	redo  []step
+
+	// mutations recorded while a group is open are added to it, and become a
+	// single step when the outermost group ends
+	group   *step
+	nesting int
+
+	// set while undoing or redoing, so that replayed mutations are not
+	// recorded again
+	replaying bool
+}
+
+type step []entry
+
+type entry struct {
+	undo func()
+	redo func()
+}
+
+// EnableHistory starts recording the mutations of the tree so that they can be
+// reversed with Undo and performed again with Redo. At most depth steps are
+// kept; when more are recorded, the oldest are forgotten. If depth is zero or
+// less, the number of steps is not bounded.
+//
+// Every mutation made through the tree is recorded, including the addition,
+// re-rooting, merging, removal and moving of nodes, and changes to node data
+// made through the Node interface. Changes to children made directly through
+// the Node interface, with AddChildren or ReplaceChildren, are not recorded;
+// undoing steps recorded before such a change may corrupt the tree.
+//
+// If history is already enabled, only its depth is changed.
+func (t *Tree[T]) EnableHistory(depth int) {
+	if t.history == nil {
+		t.history = &history{}
+	}
+	t.history.depth = depth
+	t.history.trim()
+}
+
+// DisableHistory stops recording mutations and forgets all recorded steps.
+func (t *Tree[T]) DisableHistory() {
+	t.history = nil
+}
+
+// Checkpoint starts a group of mutations that are undone and redone as a
+// single step. The group ends when the returned function is called. Groups
+// may be nested; the mutations of nested groups become part of the outermost
+// group.
+//
+// Mutations made by Tree.Apply and Tree.Batch are always recorded as a single
+// step.
+func (t *Tree[T]) Checkpoint() (end func()) {
+	g := t.group()
+	return func() { g(true) }
+}
+
+// Undo reverses the most recent step recorded in the history of the tree. It
+// returns false if there is no step to undo, if history is not enabled, or if
+// a group started with Checkpoint has not ended.
+func (t *Tree[T]) Undo() bool {
+
+	h := t.history
+	if h == nil || h.group != nil || len(h.undo) == 0 {
+		return false
+	}
+
+	s := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+
+	h.replaying = true
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i].undo()
+	}
+	h.replaying = false
+
+	h.redo = append(h.redo, s)
+	return true
+}
+
+// Redo performs again the most recent step reversed by Undo. It returns false
+// if there is no step to redo, if history is not enabled, or if a group
+// started with Checkpoint has not ended. Any new mutation of the tree
+// forgets all steps that could have been redone.
+func (t *Tree[T]) Redo() bool {
+
+	h := t.history
+	if h == nil || h.group != nil || len(h.redo) == 0 {
+		return false
+	}
+
+	s := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+
+	h.replaying = true
+	for _, e := range s {
+		e.redo()
+	}
+	h.replaying = false
+
+	h.undo = append(h.undo, s)
+	h.trim()
+	return true
+}
+
+// record adds a mutation to the history of the tree, if history is enabled.
+func (t *Tree[T]) record(undo func(), redo func()) {
+
+	h := t.history
+	if h == nil || h.replaying {
+		return
+	}
+
+	e := entry{undo: undo, redo: redo}
+	if h.group != nil {
+		*h.group = append(*h.group, e)
+		return
+	}
+
+	h.push(step{e})
+}
+
+// group opens a group of mutations recorded as a single step. The returned
+// function ends the group; if keep is false, the mutations recorded in the
+// group are forgotten, as when they have already been reversed.
+func (t *Tree[T]) group() (end func(keep bool)) {
+
+	h := t.history
+	if h == nil {
+		return func(bool) {}
+	}
+
+	h.nesting++
+	if h.group == nil {
+		h.group = &step{}
+	}
+	mark := len(*h.group)
+
+	return func(keep bool) {
+		if t.history != h || h.nesting == 0 {
+			return
+		}
+		if !keep {
+			*h.group = (*h.group)[:mark]
+		}
+		h.nesting--
+		if h.nesting == 0 {
+			g := *h.group
+			h.group = nil
+			if len(g) > 0 {
+				h.push(g)
+			}
+		}
+	}
+}
+
+func (h *history) push(s step) {
+	h.undo = append(h.undo, s)
+	h.redo = nil
+	h.trim()
+}
+
+func (h *history) trim() {
+	if h.depth > 0 && len(h.undo) > h.depth {
+		h.undo = append([]step{}, h.undo[len(h.undo)-h.depth:]...)
+	}
+}
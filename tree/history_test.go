@@ -0,0 +1,236 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndoRedo(t *testing.T) {
+
+	var tests = map[string]struct {
+		prep   func() *Tree[string]
+		change func(*Tree[string])
+	}{
+		"add": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Add(6, 5, "six")
+			},
+		},
+		"add first node": {
+			prep: Empty[string],
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+			},
+		},
+		"re-root": {
+			prep: func() *Tree[string] {
+				t := Empty[string]()
+				t.Add(1, 2, "one")
+				t.Add(3, 1, "three")
+				return t
+			},
+			change: func(t *Tree[string]) {
+				t.Add(2, 0, "two")
+			},
+		},
+		"merge": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				other := Empty[string]()
+				other.Add(6, 3, "six")
+				other.Add(7, 6, "seven")
+				t.Merge(other)
+			},
+		},
+		"set data through node": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				n, _ := t.Find(4)
+				n.SetData("FOUR")
+			},
+		},
+		"remove": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{{Type: OpRemove, ID: 2}})
+			},
+		},
+		"remove root": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{{Type: OpRemove, ID: 1}})
+			},
+		},
+		"move": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{{Type: OpMove, ID: 3, ParentID: 5}})
+			},
+		},
+		"batch": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				t.Batch(func(tx *Tx[string]) error {
+					tx.Add(6, 5, "six")
+					tx.Move(2, 6)
+					tx.SetData(3, "THREE")
+					return tx.Remove(4)
+				})
+			},
+		},
+		"checkpoint": {
+			prep: patchTestTree,
+			change: func(t *Tree[string]) {
+				end := t.Checkpoint()
+				t.Add(6, 5, "six")
+				t.Add(7, 6, "seven")
+				inner := t.Checkpoint()
+				n, _ := t.Find(7)
+				n.SetData("SEVEN")
+				inner()
+				end()
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := tt.prep()
+			tree.EnableHistory(0)
+
+			before := tree.Clone(nil)
+			tt.change(tree)
+			after := tree.Clone(nil)
+
+			assert.True(t, tree.Undo())
+			assert.True(t, Equal(before, tree, nil, true), "undo")
+			assert.Len(t, *tree.primary, len(*before.primary))
+			assert.False(t, tree.Undo())
+
+			assert.True(t, tree.Redo())
+			assert.True(t, Equal(after, tree, nil, true), "redo")
+			assert.Len(t, *tree.primary, len(*after.primary))
+			assert.False(t, tree.Redo())
+
+			assert.True(t, tree.Undo())
+			assert.True(t, Equal(before, tree, nil, true), "undo after redo")
+		})
+	}
+}
+
+func TestUndoSequence(t *testing.T) {
+
+	tree := Empty[string]()
+	tree.EnableHistory(0)
+
+	states := []*Tree[string]{tree.Clone(nil)}
+	steps := []func(){
+		func() { tree.Add(1, 0, "one") },
+		func() { tree.Add(2, 1, "two") },
+		func() { tree.Add(3, 2, "three") },
+		func() { tree.Apply(Patch[string]{{Type: OpMove, ID: 3, ParentID: 1}}) },
+		func() { tree.Apply(Patch[string]{{Type: OpRemove, ID: 2}}) },
+		func() { n, _ := tree.Find(3); n.SetData("THREE") },
+	}
+	for _, s := range steps {
+		s()
+		states = append(states, tree.Clone(nil))
+	}
+
+	for i := len(states) - 2; i >= 0; i-- {
+		assert.True(t, tree.Undo())
+		assert.True(t, Equal(states[i], tree, nil, true), "undo to state %d", i)
+	}
+	for i := 1; i < len(states); i++ {
+		assert.True(t, tree.Redo())
+		assert.True(t, Equal(states[i], tree, nil, true), "redo to state %d", i)
+	}
+}
+
+func TestHistoryDepth(t *testing.T) {
+
+	tree := Empty[int]()
+	tree.EnableHistory(2)
+
+	tree.Add(1, 0, 1)
+	tree.Add(2, 1, 2)
+	tree.Add(3, 1, 3)
+
+	assert.True(t, tree.Undo())
+	assert.True(t, tree.Undo())
+	assert.False(t, tree.Undo())
+	assert.Equal(t, []uint{1}, bfc([]Node[int]{tree.root}, []uint{}))
+
+	tree.EnableHistory(1)
+	assert.Len(t, tree.history.redo, 2)
+	assert.True(t, tree.Redo())
+	assert.True(t, tree.Redo())
+	assert.Len(t, tree.history.undo, 1)
+}
+
+func TestHistoryNewMutationClearsRedo(t *testing.T) {
+
+	tree := Empty[int]()
+	tree.EnableHistory(0)
+
+	tree.Add(1, 0, 1)
+	tree.Add(2, 1, 2)
+	assert.True(t, tree.Undo())
+	tree.Add(3, 1, 3)
+
+	assert.False(t, tree.Redo())
+	assert.Equal(t, []uint{1, 3}, bfc([]Node[int]{tree.root}, []uint{}))
+}
+
+func TestHistoryFailuresNotRecorded(t *testing.T) {
+
+	tree := patchTestTree()
+	tree.EnableHistory(0)
+
+	tree.Add(6, 9, "six")
+	tree.Apply(Patch[string]{
+		{Type: OpAdd, ID: 6, ParentID: 5},
+		{Type: OpRemove, ID: 9},
+	})
+	tree.Batch(func(tx *Tx[string]) error {
+		tx.Add(6, 5, "six")
+		return errors.New("abort")
+	})
+
+	assert.False(t, tree.Undo())
+	assert.Equal(t, []uint{1, 2, 5, 3, 4}, bfc([]Node[string]{tree.root}, []uint{}))
+}
+
+func TestHistoryDisabled(t *testing.T) {
+
+	tree := patchTestTree()
+	end := tree.Checkpoint()
+	tree.Add(6, 5, "six")
+	end()
+
+	assert.False(t, tree.Undo())
+	assert.False(t, tree.Redo())
+
+	tree.EnableHistory(0)
+	tree.Add(7, 5, "seven")
+	tree.DisableHistory()
+	assert.False(t, tree.Undo())
+}
+
+func TestUndoOpenCheckpoint(t *testing.T) {
+
+	tree := patchTestTree()
+	tree.EnableHistory(0)
+
+	tree.Add(6, 5, "six")
+	end := tree.Checkpoint()
+	tree.Add(7, 5, "seven")
+	assert.False(t, tree.Undo())
+	end()
+	assert.True(t, tree.Undo())
+	assert.True(t, tree.Undo())
+	assert.Equal(t, []uint{1, 2, 5, 3, 4}, bfc([]Node[string]{tree.root}, []uint{}))
+}
@@ -26,3 +26,16 @@ func (idx *index[T]) insert(id uint, node Node[T]) bool {
 	m[id] = node
 	return true
 }
+
+func (idx *index[T]) remove(id uint) bool {
+	if idx == nil { // do we need an error check here?
+		log.Println("Attempting to remove from an undefined index")
+		return false
+	}
+	m := *idx
+	if _, exists := m[id]; !exists {
+		return false
+	}
+	delete(m, id)
+	return true
+}
@@ -0,0 +1,504 @@
+package tree
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// The seekable format stores a tree so that single nodes and whole subtrees
+// can be read without reading the rest of the tree. It consists of:
+//   - an 8 byte magic string
+//   - the nodes of the tree, in depth first order, each encoded as a line of
+//     the format of Tree.Serialize; the nodes of any subtree are therefore
+//     stored contiguously
+//   - a table with an entry for every node, in the same depth first order,
+//     holding its primary key, its parent key, the offset and length of its
+//     record and the number of nodes in its subtree
+//   - a table mapping primary keys, in ascending order, to positions in the
+//     depth first table
+//   - a footer holding the offsets of both tables, the number of nodes and the
+//     magic string again
+//
+// All integers are unsigned 64-bit big endian values.
+const seekableMagic = "GOTREE\x00\x01"
+
+const (
+	seekableEntrySize  = 40
+	seekableKeySize    = 16
+	seekableFooterSize = 32
+)
+
+// ErrFormat indicates that data is not in the expected format.
+var ErrFormat = errors.New("invalid tree format")
+
+type seekableEntry struct {
+	id       uint64
+	parentID uint64
+	offset   uint64
+	length   uint64
+	size     uint64 // number of nodes in the subtree
+}
+
+// WriteSeekable writes the tree to w in a seekable format that can be read
+// lazily with OpenLazy. The data of each node must be serializable using the
+// json package.
+func (t *Tree[T]) WriteSeekable(w io.Writer) error {
+
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, seekableMagic); err != nil {
+		return fmt.Errorf("error writing seekable tree: %w", err)
+	}
+
+	entries := []seekableEntry{}
+	var encode func(n Node[T]) (uint64, error)
+	encode = func(n Node[T]) (uint64, error) {
+
+		record, err := json.Marshal(serialNode[T]{
+			Primary:  n.GetID(),
+			ParentID: n.GetParentID(),
+			Data:     n.GetData(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		record = append(record, '\n')
+
+		pos := len(entries)
+		entries = append(entries, seekableEntry{
+			id:       uint64(n.GetID()),
+			parentID: uint64(n.GetParentID()),
+			offset:   uint64(cw.n),
+			length:   uint64(len(record)),
+		})
+		if _, err := cw.Write(record); err != nil {
+			return 0, err
+		}
+
+		size := uint64(1)
+		for _, c := range n.GetChildren() {
+			s, err := encode(c)
+			if err != nil {
+				return 0, err
+			}
+			size += s
+		}
+		entries[pos].size = size
+		return size, nil
+	}
+
+	if t.root != nil {
+		if _, err := encode(t.root); err != nil {
+			return fmt.Errorf("error writing seekable tree: %w", err)
+		}
+	}
+
+	dfsOffset := uint64(cw.n)
+	buf := make([]byte, seekableEntrySize)
+	for _, e := range entries {
+		binary.BigEndian.PutUint64(buf[0:], e.id)
+		binary.BigEndian.PutUint64(buf[8:], e.parentID)
+		binary.BigEndian.PutUint64(buf[16:], e.offset)
+		binary.BigEndian.PutUint64(buf[24:], e.length)
+		binary.BigEndian.PutUint64(buf[32:], e.size)
+		if _, err := cw.Write(buf); err != nil {
+			return fmt.Errorf("error writing seekable tree: %w", err)
+		}
+	}
+
+	keys := make([]int, len(entries))
+	for i := range keys {
+		keys[i] = i
+	}
+	sort.Slice(keys, func(i, j int) bool { return entries[keys[i]].id < entries[keys[j]].id })
+
+	idOffset := uint64(cw.n)
+	buf = buf[:seekableKeySize]
+	for _, pos := range keys {
+		binary.BigEndian.PutUint64(buf[0:], entries[pos].id)
+		binary.BigEndian.PutUint64(buf[8:], uint64(pos))
+		if _, err := cw.Write(buf); err != nil {
+			return fmt.Errorf("error writing seekable tree: %w", err)
+		}
+	}
+
+	footer := make([]byte, seekableFooterSize)
+	binary.BigEndian.PutUint64(footer[0:], dfsOffset)
+	binary.BigEndian.PutUint64(footer[8:], idOffset)
+	binary.BigEndian.PutUint64(footer[16:], uint64(len(entries)))
+	copy(footer[24:], seekableMagic)
+	if _, err := cw.Write(footer); err != nil {
+		return fmt.Errorf("error writing seekable tree: %w", err)
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// LazyTree is a read-only tree, stored in the format written by
+// Tree.WriteSeekable, whose nodes are read only when they are reached by
+// Find, FindParents, GetChildren or traversal. Up to a fixed number of nodes
+// read are kept in memory, the least recently used being discarded first.
+//
+// The nodes returned by a LazyTree cannot be modified; the methods of the Node
+// interface that modify a node panic. Because the methods of the Node
+// interface cannot return errors, an error reading or decoding the underlying
+// data makes the node appear absent; the first such error is reported by Err.
+//
+// A LazyTree is safe for concurrent use, provided its io.ReaderAt is.
+type LazyTree[T any] struct {
+	r         io.ReaderAt
+	dfsOffset int64
+	idOffset  int64
+	count     int64
+
+	mu    sync.Mutex
+	limit int
+	cache map[uint64]*list.Element // positions in the depth first table
+	lru   *list.List
+	err   error
+}
+
+// OpenLazy opens a tree written by Tree.WriteSeekable. The argument size is
+// the total size of the data in r. At most cacheLimit nodes are kept in
+// memory; if cacheLimit is zero or less, no nodes are kept and every access
+// reads from r.
+//
+// Only the footer is read when the tree is opened. An error wrapping
+// ErrFormat is returned if it is not a valid footer.
+func OpenLazy[T any](r io.ReaderAt, size int64, cacheLimit int) (*LazyTree[T], error) {
+
+	if size < int64(len(seekableMagic)+seekableFooterSize) {
+		return nil, fmt.Errorf("error opening lazy tree: %w", ErrFormat)
+	}
+
+	footer := make([]byte, seekableFooterSize)
+	if _, err := r.ReadAt(footer, size-seekableFooterSize); err != nil {
+		return nil, fmt.Errorf("error opening lazy tree: %w", err)
+	}
+	if string(footer[24:]) != seekableMagic {
+		return nil, fmt.Errorf("error opening lazy tree: %w", ErrFormat)
+	}
+
+	l := &LazyTree[T]{
+		r:         r,
+		dfsOffset: int64(binary.BigEndian.Uint64(footer[0:])),
+		idOffset:  int64(binary.BigEndian.Uint64(footer[8:])),
+		count:     int64(binary.BigEndian.Uint64(footer[16:])),
+		limit:     cacheLimit,
+		cache:     map[uint64]*list.Element{},
+		lru:       list.New(),
+	}
+
+	if l.dfsOffset < int64(len(seekableMagic)) || l.count < 0 ||
+		l.dfsOffset+l.count*seekableEntrySize != l.idOffset ||
+		l.idOffset+l.count*seekableKeySize != size-seekableFooterSize {
+		return nil, fmt.Errorf("error opening lazy tree: %w", ErrFormat)
+	}
+
+	return l, nil
+}
+
+// Err returns the first error that occurred reading the tree, if any.
+func (l *LazyTree[T]) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Len returns the number of nodes in the tree.
+func (l *LazyTree[T]) Len() int {
+	return int(l.count)
+}
+
+// Root returns the root node of the tree. If the tree has no nodes, this
+// function returns nil.
+func (l *LazyTree[T]) Root() Node[T] {
+	if l.count == 0 {
+		return nil
+	}
+	if n := l.node(0); n != nil {
+		return n
+	}
+	return nil
+}
+
+// Find looks up a node by its primary key. See Tree.Find.
+func (l *LazyTree[T]) Find(id uint) (n Node[T], ok bool) {
+	pos, found := l.position(uint64(id))
+	if !found {
+		return
+	}
+	if ln := l.node(pos); ln != nil {
+		return ln, true
+	}
+	return
+}
+
+// FindParents finds the list of all parent nodes between a target node and the
+// root of the tree. See Tree.FindParents.
+func (l *LazyTree[T]) FindParents(id uint) (parents []Node[T], ok bool) {
+
+	f, ok := l.Find(id)
+	if !ok {
+		return
+	}
+
+	for n := f.GetParent(); n != nil; n = n.GetParent() {
+		parents = append(parents, n)
+	}
+
+	return parents, true
+}
+
+// Traverse visits each node of the tree in a specified order, reading nodes
+// as they are reached. See Tree.Traverse.
+func (l *LazyTree[T]) Traverse(trvsl TraversalType) <-chan Node[T] {
+	return traverse(l.Root(), trvsl)
+}
+
+// Subtree reads the node with the given primary key, along with all of its
+// descendents, into a new Tree. The subtree is read with a single contiguous
+// read, without reading any other node of the tree. The nodes read are not
+// added to the cache.
+func (l *LazyTree[T]) Subtree(id uint) (*Tree[T], error) {
+
+	pos, found := l.position(uint64(id))
+	if !found {
+		if err := l.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("node %d: %w", id, ErrNotFound)
+	}
+
+	first, err := l.entry(pos)
+	if err != nil {
+		return nil, err
+	}
+	last, err := l.entry(pos + first.size - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if last.offset < first.offset {
+		return nil, fmt.Errorf("error reading lazy tree: subtree of node %d out of order: %w", id, ErrFormat)
+	}
+	section := io.NewSectionReader(l.r, int64(first.offset), int64(last.offset+last.length-first.offset))
+	// the records hold the data as it was when the tree was written, at the
+	// current schema version
+	return deserialize[T](section, true)
+}
+
+// position finds the position of a primary key in the depth first table by
+// binary search of the key table.
+func (l *LazyTree[T]) position(id uint64) (uint64, bool) {
+
+	buf := make([]byte, seekableKeySize)
+	var readErr error
+
+	i := sort.Search(int(l.count), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		if _, err := l.r.ReadAt(buf, l.idOffset+int64(i)*seekableKeySize); err != nil {
+			readErr = err
+			return true
+		}
+		return binary.BigEndian.Uint64(buf[0:]) >= id
+	})
+
+	if readErr != nil {
+		l.fail(fmt.Errorf("error reading lazy tree index: %w", readErr))
+		return 0, false
+	}
+	if i >= int(l.count) {
+		return 0, false
+	}
+	if _, err := l.r.ReadAt(buf, l.idOffset+int64(i)*seekableKeySize); err != nil {
+		l.fail(fmt.Errorf("error reading lazy tree index: %w", err))
+		return 0, false
+	}
+	if binary.BigEndian.Uint64(buf[0:]) != id {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(buf[8:]), true
+}
+
+func (l *LazyTree[T]) entry(pos uint64) (seekableEntry, error) {
+
+	if pos >= uint64(l.count) {
+		return seekableEntry{}, fmt.Errorf("error reading lazy tree: position %d: %w", pos, ErrFormat)
+	}
+
+	buf := make([]byte, seekableEntrySize)
+	if _, err := l.r.ReadAt(buf, l.dfsOffset+int64(pos)*seekableEntrySize); err != nil {
+		return seekableEntry{}, fmt.Errorf("error reading lazy tree: %w", err)
+	}
+
+	e := seekableEntry{
+		id:       binary.BigEndian.Uint64(buf[0:]),
+		parentID: binary.BigEndian.Uint64(buf[8:]),
+		offset:   binary.BigEndian.Uint64(buf[16:]),
+		length:   binary.BigEndian.Uint64(buf[24:]),
+		size:     binary.BigEndian.Uint64(buf[32:]),
+	}
+
+	// the record must lie between the magic and the depth first table, and
+	// the subtree within the table, before anything is allocated for them
+	records := uint64(l.dfsOffset)
+	if e.length == 0 || e.offset < uint64(len(seekableMagic)) ||
+		e.length > records || e.offset > records-e.length ||
+		e.size == 0 || e.size > uint64(l.count)-pos {
+		return seekableEntry{}, fmt.Errorf("error reading lazy tree: entry %d out of bounds: %w", pos, ErrFormat)
+	}
+
+	return e, nil
+}
+
+// node returns the node at a position of the depth first table, from the
+// cache if possible. It returns nil if the node cannot be read.
+func (l *LazyTree[T]) node(pos uint64) *lazyNode[T] {
+
+	l.mu.Lock()
+	if el, ok := l.cache[pos]; ok {
+		l.lru.MoveToFront(el)
+		l.mu.Unlock()
+		return el.Value.(*lazyNode[T])
+	}
+	l.mu.Unlock()
+
+	n, err := l.read(pos)
+	if err != nil {
+		l.fail(err)
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit > 0 {
+		if el, ok := l.cache[pos]; ok { // read concurrently by another caller
+			return el.Value.(*lazyNode[T])
+		}
+		l.cache[pos] = l.lru.PushFront(n)
+		for l.lru.Len() > l.limit {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.cache, oldest.Value.(*lazyNode[T]).pos)
+		}
+	}
+	return n
+}
+
+func (l *LazyTree[T]) read(pos uint64) (*lazyNode[T], error) {
+
+	e, err := l.entry(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, e.length)
+	if _, err := l.r.ReadAt(record, int64(e.offset)); err != nil {
+		return nil, fmt.Errorf("error reading lazy tree node %d: %w", e.id, err)
+	}
+
+	var sn serialNode[T]
+	if err := json.NewDecoder(bytes.NewReader(record)).Decode(&sn); err != nil {
+		return nil, fmt.Errorf("error decoding lazy tree node %d: %w", e.id, err)
+	}
+	if uint64(sn.Primary) != e.id {
+		return nil, fmt.Errorf("error decoding lazy tree node %d: %w", e.id, ErrFormat)
+	}
+
+	return &lazyNode[T]{tree: l, pos: pos, entry: e, data: sn.Data}, nil
+}
+
+func (l *LazyTree[T]) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+// lazyNode implements the Node interface for a node of a LazyTree.
+type lazyNode[T any] struct {
+	tree  *LazyTree[T]
+	pos   uint64
+	entry seekableEntry
+	data  T
+}
+
+func (n *lazyNode[T]) GetID() uint {
+	return uint(n.entry.id)
+}
+
+func (n *lazyNode[T]) GetParentID() uint {
+	return uint(n.entry.parentID)
+}
+
+func (n *lazyNode[T]) GetChildren() []Node[T] {
+
+	children := []Node[T]{}
+	end := n.pos + n.entry.size
+
+	for pos := n.pos + 1; pos < end; {
+		c := n.tree.node(pos)
+		if c == nil {
+			break
+		}
+		children = append(children, c)
+		pos += c.entry.size
+	}
+
+	return children
+}
+
+func (n *lazyNode[T]) GetParent() Node[T] {
+	if n.pos == 0 {
+		return nil
+	}
+	if p, ok := n.tree.Find(n.GetParentID()); ok {
+		return p
+	}
+	return nil
+}
+
+func (n *lazyNode[T]) GetData() T {
+	return n.data
+}
+
+func (n *lazyNode[T]) AddChildren(...Node[T]) {
+	panic("tree: cannot add children to a node of a lazy tree")
+}
+
+func (n *lazyNode[T]) ReplaceChildren(...Node[T]) {
+	panic("tree: cannot replace children of a node of a lazy tree")
+}
+
+func (n *lazyNode[T]) SetData(T) {
+	panic("tree: cannot set data of a node of a lazy tree")
+}
+
+func (n *lazyNode[T]) setParent(Node[T]) {
+	panic("tree: cannot set parent of a node of a lazy tree")
+}
+
+func (n *lazyNode[T]) unsetParent() {
+	panic("tree: cannot unset parent of a node of a lazy tree")
+}
@@ -0,0 +1,224 @@
+package tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingReaderAt counts the bytes read through it.
+type countingReaderAt struct {
+	r     *bytes.Reader
+	bytes int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	atomic.AddInt64(&c.bytes, int64(n))
+	return n, err
+}
+
+func openTestLazy(t *testing.T, tree *Tree[string], cacheLimit int) (*LazyTree[string], *countingReaderAt) {
+	var buf bytes.Buffer
+	require.NoError(t, tree.WriteSeekable(&buf))
+	r := &countingReaderAt{r: bytes.NewReader(buf.Bytes())}
+	l, err := OpenLazy[string](r, int64(buf.Len()), cacheLimit)
+	require.NoError(t, err)
+	return l, r
+}
+
+func TestLazyTree(t *testing.T) {
+
+	var tests = map[string]struct {
+		prep       func() *Tree[string]
+		cacheLimit int
+	}{
+		"empty": {
+			prep: Empty[string],
+		},
+		"single node": {
+			prep: func() *Tree[string] {
+				t := Empty[string]()
+				t.Add(1, 0, "one")
+				return t
+			},
+		},
+		"no cache": {
+			prep: patchTestTree,
+		},
+		"small cache": {
+			prep:       patchTestTree,
+			cacheLimit: 2,
+		},
+		"large cache": {
+			prep:       patchTestTree,
+			cacheLimit: 100,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := tt.prep()
+			l, _ := openTestLazy(t, tree, tt.cacheLimit)
+
+			assert.Equal(t, len(*tree.primary), l.Len())
+			assert.Equal(t, bfc([]Node[string]{tree.root}, []uint{}), bfc([]Node[string]{l.Root()}, []uint{}))
+			assert.Equal(t, dfc[string](tree.root, []uint{}), dfc(l.Root(), []uint{}))
+
+			for id, exp := range *tree.primary {
+				n, ok := l.Find(id)
+				require.True(t, ok)
+				assert.Equal(t, exp.GetData(), n.GetData())
+				assert.Equal(t, exp.GetParentID(), n.GetParentID())
+
+				expParents, _ := tree.FindParents(id)
+				parents, ok := l.FindParents(id)
+				assert.True(t, ok)
+				assert.Equal(t, len(expParents), len(parents))
+				for i := range parents {
+					assert.Equal(t, expParents[i].GetID(), parents[i].GetID())
+				}
+			}
+
+			for _, trvsl := range []TraversalType{TraverseBreadthFirst, TraverseDepthFirst} {
+				exp := []uint{}
+				for n := range tree.Traverse(trvsl) {
+					exp = append(exp, n.GetID())
+				}
+				got := []uint{}
+				for n := range l.Traverse(trvsl) {
+					got = append(got, n.GetID())
+				}
+				assert.Equal(t, exp, got)
+			}
+
+			_, ok := l.Find(99)
+			assert.False(t, ok)
+			assert.NoError(t, l.Err())
+		})
+	}
+}
+
+func TestLazyTreeReadsOnDemand(t *testing.T) {
+
+	tree := Empty[string]()
+	tree.Add(1, 0, "root")
+	for i := uint(2); i <= 200; i++ {
+		tree.Add(i, (i-2)/10+1, "data")
+	}
+	l, r := openTestLazy(t, tree, 0)
+
+	n, ok := l.Find(150)
+	require.True(t, ok)
+	assert.Equal(t, "data", n.GetData())
+	assert.Less(t, r.bytes, int64(1024))
+
+	sub, err := l.Subtree(2)
+	require.NoError(t, err)
+	exp := dfc[string](tree.primary.find(2), []uint{})
+	assert.Equal(t, exp, dfc(sub.root, []uint{}))
+	assert.Len(t, *sub.primary, len(exp))
+
+	_, err = l.Subtree(999)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLazyTreeCache(t *testing.T) {
+
+	l, r := openTestLazy(t, patchTestTree(), 2)
+
+	// a lookup always searches the key table, but reads the node record only
+	// when the node is not cached
+	find := func(id uint) int64 {
+		before := atomic.LoadInt64(&r.bytes)
+		l.Find(id)
+		return atomic.LoadInt64(&r.bytes) - before
+	}
+
+	uncached := find(3)
+	cached := find(3)
+	assert.Less(t, cached, uncached, "cached node read again")
+
+	find(4)
+	find(5)
+	assert.Equal(t, 2, l.lru.Len())
+	assert.Equal(t, uncached, find(3), "evicted node not read again")
+}
+
+func TestLazyTreeReadOnly(t *testing.T) {
+
+	l, _ := openTestLazy(t, patchTestTree(), 0)
+	n, _ := l.Find(2)
+
+	assert.Panics(t, func() { n.SetData("x") })
+	assert.Panics(t, func() { n.AddChildren() })
+	assert.Panics(t, func() { n.ReplaceChildren() })
+}
+
+func TestOpenLazyInvalid(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, patchTestTree().WriteSeekable(&buf))
+	data := buf.Bytes()
+
+	var tests = map[string][]byte{
+		"too short":     data[:10],
+		"bad magic":     append(append([]byte{}, data[:len(data)-1]...), 'x'),
+		"truncated":     data[len(data)/2:],
+		"serialization": []byte(`{"Primary":1,"ParentID":0,"Data":"one"}` + "\n" + `{"Primary":2,"ParentID":1,"Data":"two"}` + "\n"),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := OpenLazy[string](bytes.NewReader(data), int64(len(data)), 0)
+			assert.ErrorIs(t, err, ErrFormat)
+		})
+	}
+}
+
+func TestLazyCorruptEntry(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, patchTestTree().WriteSeekable(&buf))
+	clean := buf.Bytes()
+
+	// fields of a depth first table entry, by byte offset
+	const offset, length, size = 16, 24, 32
+
+	var tests = map[string]struct {
+		field int
+		value uint64
+	}{
+		"huge length":      {field: length, value: 1 << 62},
+		"zero length":      {field: length, value: 0},
+		"length past data": {field: length, value: uint64(len(clean))},
+		"huge offset":      {field: offset, value: 1<<64 - 1},
+		"offset in magic":  {field: offset, value: 2},
+		"zero size":        {field: size, value: 0},
+		"huge size":        {field: size, value: 1 << 62},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := append([]byte{}, clean...)
+			l, err := OpenLazy[string](bytes.NewReader(data), int64(len(data)), 0)
+			require.NoError(t, err)
+			pos, ok := l.position(2)
+			require.True(t, ok)
+
+			at := l.dfsOffset + int64(pos)*seekableEntrySize + int64(tt.field)
+			binary.BigEndian.PutUint64(data[at:], tt.value)
+
+			_, ok = l.Find(2)
+			assert.False(t, ok)
+			assert.ErrorIs(t, l.Err(), ErrFormat)
+
+			_, err = l.Subtree(2)
+			assert.ErrorIs(t, err, ErrFormat)
+		})
+	}
+}
@@ -49,6 +49,7 @@ type Node[T any] interface {
 	ReplaceChildren(...Node[T])
 
 	setParent(n Node[T])
+	unsetParent()
 
 	// GetData retruns this node's internal data.
 	GetData() T
@@ -67,6 +68,7 @@ type node[T any] struct {
 	parent   Node[T]
 	data     T
 	children []Node[T]
+	owner    *Tree[T]
 }
 
 func (n *node[T]) GetID() uint {
@@ -106,12 +108,23 @@ func (n *node[T]) setParent(parent Node[T]) {
 
 }
 
+func (n *node[T]) unsetParent() {
+	n.parent = nil
+}
+
 func (n *node[T]) GetData() T {
 	return n.data
 }
 
 func (n *node[T]) SetData(newdata T) {
+	old := n.data
 	n.data = newdata
+	// a node removed from its tree keeps its owner, so that it can be put back
+	// by undo, but its changes are no longer part of the tree
+	if n.owner != nil && n.owner.primary.find(n.primary) == Node[T](n) {
+		n.owner.record(func() { n.SetData(old) }, func() { n.SetData(newdata) })
+		n.owner.emit(Event[T]{Type: DataChanged, Node: n, OldData: old})
+	}
 }
 
 func (n *node[T]) Format(f fmt.State, verb rune) {
@@ -0,0 +1,250 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// OpType identifies the kind of mutation described by an Op.
+type OpType int
+
+const (
+	// OpAdd inserts a new node into the tree, following the rules of
+	// Tree.Add.
+	OpAdd OpType = iota
+	// OpRemove removes a node, along with all of its descendents, from the
+	// tree.
+	OpRemove
+	// OpMove changes the parent of a node. The node's descendents move with
+	// it.
+	OpMove
+	// OpSetData replaces the data of a node.
+	OpSetData
+)
+
+var opNames = map[OpType]string{
+	OpAdd:     "add",
+	OpRemove:  "remove",
+	OpMove:    "move",
+	OpSetData: "set",
+}
+
+// String returns the name of the operation type.
+func (o OpType) String() string {
+	if name, ok := opNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpType(%d)", int(o))
+}
+
+// MarshalText encodes the operation type by its name.
+func (o OpType) MarshalText() ([]byte, error) {
+	if name, ok := opNames[o]; ok {
+		return []byte(name), nil
+	}
+	return nil, fmt.Errorf("unknown operation type %d", int(o))
+}
+
+// UnmarshalText decodes an operation type from its name.
+func (o *OpType) UnmarshalText(text []byte) error {
+	for k, name := range opNames {
+		if name == string(text) {
+			*o = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown operation type %q", text)
+}
+
+// Op is a single mutation of a tree. The fields used depend on the type of
+// operation:
+//   - OpAdd uses ID, ParentID and Data
+//   - OpRemove uses ID
+//   - OpMove uses ID and ParentID, the primary key of the new parent
+//   - OpSetData uses ID and Data
+type Op[T any] struct {
+	Type     OpType
+	ID       uint
+	ParentID uint `json:",omitempty"`
+	Data     T
+}
+
+// Patch is an ordered list of operations, or change set, that can be applied
+// to a tree.
+type Patch[T any] []Op[T]
+
+// Apply performs every operation of a patch on the tree, in order. A patch is
+// applied atomically; if any operation fails, all operations of the patch that
+// were already performed are reversed, the tree is left unchanged and an
+// error identifying the failed operation is returned.
+//
+// An operation fails with ErrNotFound if the node it targets, or the parent
+// it references, is not in the tree; with ErrExists if it adds a node whose
+// primary key is already in the tree; with ErrCycle if a node would become
+// its own ancestor; and with ErrIsRoot if it attempts to move the root.
+func (t *Tree[T]) Apply(p Patch[T]) error {
+	_, err := t.applyPatch(p)
+	return err
+}
+
+// applyPatch applies a patch atomically. If successful, it returns the
+// functions that reverse each operation of the patch, in the order the
+// operations were applied.
+func (t *Tree[T]) applyPatch(p Patch[T]) (undo []func(), err error) {
+
+	undo = make([]func(), 0, len(p))
+	release := t.hold()
+	end := t.group()
+
+	for i, op := range p {
+		u, err := t.apply(op)
+		if err != nil {
+			rollback(undo)
+			end(false)
+			release(false)
+			return nil, fmt.Errorf("patch operation %d (%s %d): %w", i, op.Type, op.ID, err)
+		}
+		undo = append(undo, u)
+	}
+
+	end(true)
+	release(true)
+	return undo, nil
+}
+
+// rollback calls a list of functions that reverse operations, last first.
+func rollback(undo []func()) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		undo[i]()
+	}
+}
+
+// apply performs a single operation on the tree. If successful, it returns a
+// function that reverses the operation.
+func (t *Tree[T]) apply(op Op[T]) (undo func(), err error) {
+	switch op.Type {
+	case OpAdd:
+		return t.applyAdd(op)
+	case OpRemove:
+		n, parent, pos, ok := t.detach(op.ID)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return func() { t.attach(n, parent, pos) }, nil
+	case OpMove:
+		oldParent, pos, err := t.move(op.ID, op.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			t.relink(t.primary.find(op.ID), oldParent, pos)
+		}, nil
+	case OpSetData:
+		n := t.primary.find(op.ID)
+		if n == nil {
+			return nil, ErrNotFound
+		}
+		old := n.GetData()
+		n.SetData(op.Data)
+		return func() { n.SetData(old) }, nil
+	default:
+		return nil, fmt.Errorf("unknown operation type %d", int(op.Type))
+	}
+}
+
+func (t *Tree[T]) applyAdd(op Op[T]) (undo func(), err error) {
+
+	oldRoot := t.root
+
+	added, exists := t.Add(op.ID, op.ParentID, op.Data)
+	if exists {
+		return nil, ErrExists
+	}
+	if !added {
+		if t.primary.find(op.ParentID) != nil {
+			return nil, ErrCycle
+		}
+		return nil, ErrNotFound
+	}
+
+	n := t.primary.find(op.ID)
+	return func() { t.unadd(n, oldRoot) }, nil
+}
+
+// unadd reverses the addition of node n, which must have no children other
+// than a root it was added above. oldRoot is the root of the tree before n
+// was added.
+func (t *Tree[T]) unadd(n Node[T], oldRoot Node[T]) {
+
+	if oldRoot == nil || t.root != n { // n was not added above the root
+		t.detach(n.GetID())
+		return
+	}
+
+	// the tree was re-rooted. Removing n removes its whole subtree, the former
+	// root included, which is then added back as the root. Each event follows
+	// its own change, so that observers acting on the tree during an event,
+	// such as a LogStore compacting, find the tree as the events describe it.
+	walk(n, func(d Node[T]) {
+		t.primary.remove(d.GetID())
+	})
+	t.root = nil
+	t.emit(Event[T]{Type: Removed, Node: n, OldParentID: n.GetParentID()})
+
+	n.ReplaceChildren()
+	oldRoot.unsetParent()
+	t.attach(oldRoot, nil, 0)
+}
+
+// Serialize encodes the patch as a byte stream, one operation per line, so that
+// it may be shipped to another holder of the tree in place of the whole tree.
+//
+// As with Tree.Serialize, the data of each operation must be serializable
+// using the json package. Encoding is done in a goroutine that populates the
+// ReadCloser as it is consumed; any encoding error is passed back through the
+// returned channel and stops serialization.
+func (p Patch[T]) Serialize() (io.ReadCloser, <-chan error) {
+	reader, writer := io.Pipe()
+	errchan := make(chan error)
+
+	go func() {
+		encoder := json.NewEncoder(writer)
+		for _, op := range p {
+			if err := encoder.Encode(op); err != nil {
+				errchan <- err
+				writer.Close()
+				return
+			}
+		}
+
+		close(errchan)
+		writer.Close()
+	}()
+
+	return reader, errchan
+}
+
+// DeserializePatch decodes a data stream, encoded with Patch.Serialize, into a
+// patch. If any operation fails to deserialize, this function aborts and
+// returns an error.
+func DeserializePatch[T any](stream io.ReadCloser) (Patch[T], error) {
+	decoder := json.NewDecoder(stream)
+	p := Patch[T]{}
+
+	for {
+
+		var op Op[T]
+
+		err := decoder.Decode(&op)
+		if err == io.EOF {
+			return p, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error deserializing patch: %w", err)
+		}
+
+		p = append(p, op)
+	}
+}
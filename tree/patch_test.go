@@ -0,0 +1,246 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// patchTestTree builds the tree
+//
+//	1
+//	├── 2
+//	│   ├── 3
+//	│   └── 4
+//	└── 5
+func patchTestTree() *Tree[string] {
+	t := Empty[string]()
+	t.Add(1, 0, "one")
+	t.Add(2, 1, "two")
+	t.Add(3, 2, "three")
+	t.Add(4, 2, "four")
+	t.Add(5, 1, "five")
+	return t
+}
+
+func TestApply(t *testing.T) {
+
+	var tests = map[string]struct {
+		patch   Patch[string]
+		expErr  error
+		expBFC  []uint
+		expDFC  []uint
+		expData map[uint]string
+	}{
+		"empty patch": {
+			patch:  Patch[string]{},
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"add": {
+			patch: Patch[string]{
+				{Type: OpAdd, ID: 6, ParentID: 5, Data: "six"},
+				{Type: OpAdd, ID: 7, ParentID: 6, Data: "seven"},
+			},
+			expBFC:  []uint{1, 2, 5, 3, 4, 6, 7},
+			expDFC:  []uint{1, 2, 3, 4, 5, 6, 7},
+			expData: map[uint]string{6: "six", 7: "seven"},
+		},
+		"add orphan rolls back": {
+			patch: Patch[string]{
+				{Type: OpMove, ID: 3, ParentID: 5},
+				{Type: OpAdd, ID: 9, ParentID: 0, Data: "nine"},
+			},
+			expErr: ErrNotFound,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"remove": {
+			patch: Patch[string]{
+				{Type: OpRemove, ID: 2},
+			},
+			expBFC: []uint{1, 5},
+			expDFC: []uint{1, 5},
+		},
+		"move": {
+			patch: Patch[string]{
+				{Type: OpMove, ID: 2, ParentID: 5},
+			},
+			expBFC: []uint{1, 5, 2, 3, 4},
+			expDFC: []uint{1, 5, 2, 3, 4},
+		},
+		"set data": {
+			patch: Patch[string]{
+				{Type: OpSetData, ID: 4, Data: "FOUR"},
+			},
+			expBFC:  []uint{1, 2, 5, 3, 4},
+			expDFC:  []uint{1, 2, 3, 4, 5},
+			expData: map[uint]string{4: "FOUR"},
+		},
+		"mixed": {
+			patch: Patch[string]{
+				{Type: OpAdd, ID: 6, ParentID: 5, Data: "six"},
+				{Type: OpMove, ID: 3, ParentID: 6},
+				{Type: OpRemove, ID: 2},
+				{Type: OpSetData, ID: 3, Data: "THREE"},
+			},
+			expBFC:  []uint{1, 5, 6, 3},
+			expDFC:  []uint{1, 5, 6, 3},
+			expData: map[uint]string{3: "THREE"},
+		},
+		"add existing rolls back": {
+			patch: Patch[string]{
+				{Type: OpAdd, ID: 6, ParentID: 5, Data: "six"},
+				{Type: OpSetData, ID: 1, Data: "ONE"},
+				{Type: OpAdd, ID: 3, ParentID: 6, Data: "three"},
+			},
+			expErr:  ErrExists,
+			expBFC:  []uint{1, 2, 5, 3, 4},
+			expDFC:  []uint{1, 2, 3, 4, 5},
+			expData: map[uint]string{1: "one"},
+		},
+		"remove then use rolls back": {
+			patch: Patch[string]{
+				{Type: OpRemove, ID: 2},
+				{Type: OpSetData, ID: 3, Data: "THREE"},
+			},
+			expErr:  ErrNotFound,
+			expBFC:  []uint{1, 2, 5, 3, 4},
+			expDFC:  []uint{1, 2, 3, 4, 5},
+			expData: map[uint]string{3: "three"},
+		},
+		"remove root rolls back": {
+			patch: Patch[string]{
+				{Type: OpRemove, ID: 1},
+				{Type: OpRemove, ID: 1},
+			},
+			expErr: ErrNotFound,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"move beneath descendent": {
+			patch: Patch[string]{
+				{Type: OpMove, ID: 4, ParentID: 5},
+				{Type: OpMove, ID: 2, ParentID: 3},
+			},
+			expErr: ErrCycle,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"move root": {
+			patch: Patch[string]{
+				{Type: OpMove, ID: 1, ParentID: 5},
+			},
+			expErr: ErrIsRoot,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"move restores child order": {
+			patch: Patch[string]{
+				{Type: OpMove, ID: 3, ParentID: 5},
+				{Type: OpSetData, ID: 8},
+			},
+			expErr: ErrNotFound,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := patchTestTree()
+			gotErr := tree.Apply(tt.patch)
+
+			if tt.expErr != nil {
+				assert.ErrorIs(t, gotErr, tt.expErr)
+			} else {
+				assert.NoError(t, gotErr)
+			}
+
+			assert.Equal(t, tt.expBFC, bfc([]Node[string]{tree.root}, []uint{}))
+			assert.Equal(t, tt.expDFC, dfc(tree.root, []uint{}))
+			assert.Len(t, *tree.primary, len(tt.expBFC))
+
+			for _, key := range tt.expBFC {
+				k := tree.primary.find(key)
+				if assert.NotNil(t, k, "Expected value for %d not to be nil", key) {
+					assert.Equal(t, key, k.GetID())
+				}
+			}
+			for id, data := range tt.expData {
+				n, _ := tree.Find(id)
+				assert.Equal(t, data, n.GetData())
+			}
+		})
+	}
+}
+
+func TestApplyReroot(t *testing.T) {
+
+	tree := Empty[string]()
+	tree.Add(1, 2, "one")
+	tree.Add(3, 1, "three")
+
+	err := tree.Apply(Patch[string]{
+		{Type: OpAdd, ID: 2, ParentID: 0, Data: "two"},
+		{Type: OpAdd, ID: 1, ParentID: 2, Data: "one"},
+	})
+
+	assert.ErrorIs(t, err, ErrExists)
+	assert.Equal(t, uint(1), tree.Root().GetID())
+	assert.Nil(t, tree.Root().GetParent())
+	assert.Equal(t, []uint{1, 3}, bfc([]Node[string]{tree.root}, []uint{}))
+	_, ok := tree.Find(2)
+	assert.False(t, ok)
+
+	err = tree.Apply(Patch[string]{
+		{Type: OpAdd, ID: 2, ParentID: 0, Data: "two"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{2, 1, 3}, bfc([]Node[string]{tree.root}, []uint{}))
+}
+
+func TestPatchSerialize(t *testing.T) {
+
+	type elem struct {
+		Name string
+		Tags []string
+	}
+
+	var tests = map[string]struct {
+		patch Patch[elem]
+	}{
+		"empty": {
+			patch: Patch[elem]{},
+		},
+		"all operations": {
+			patch: Patch[elem]{
+				{Type: OpAdd, ID: 6, ParentID: 5, Data: elem{"six", []string{"a"}}},
+				{Type: OpMove, ID: 3, ParentID: 6},
+				{Type: OpRemove, ID: 2},
+				{Type: OpSetData, ID: 3, Data: elem{"three", nil}},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rdr, senderr := tt.patch.Serialize()
+
+			gotPatch, gotErr := DeserializePatch[elem](rdr)
+
+			assert.NoError(t, gotErr)
+			assert.NoError(t, <-senderr)
+			assert.Equal(t, tt.patch, gotPatch)
+		})
+	}
+}
+
+func TestOpTypeUnmarshalText(t *testing.T) {
+
+	var o OpType
+	assert.Error(t, o.UnmarshalText([]byte("rename")))
+	assert.NoError(t, o.UnmarshalText([]byte("move")))
+	assert.Equal(t, OpMove, o)
+}
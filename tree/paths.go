@@ -0,0 +1,231 @@
+package tree
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrNameCollision indicates that two or more children of a node have the
+// same name, so that a path to either of them is ambiguous.
+var ErrNameCollision = errors.New("sibling names collide")
+
+// NameCollision describes children of a node that have the same name.
+type NameCollision struct {
+	ParentID uint
+	Name     string
+	IDs      []uint
+}
+
+// pathIndex indexes the children of every node by name. It is kept up to
+// date from the events of the tree.
+type pathIndex[T any] struct {
+	name     func(T) string
+	children map[uint]map[string][]uint // parent key to name to child keys
+	entries  map[uint]pathEntry         // child key to its place in children
+	cancel   func()
+}
+
+type pathEntry struct {
+	parentID uint
+	name     string
+}
+
+// SetNamer gives every node of the tree a name, computed from its data by
+// name, so that nodes can be found by path with FindByPath. The path of a node
+// is the names of the nodes from the root down to it, each preceded by a
+// slash; the path of the root is "/", and the name of the root is not part of
+// any path. Names should not be empty or contain a slash.
+//
+// The names are kept in an index that follows every change to the tree,
+// including changes to node data. If two children of a node have the same
+// name, both are kept in the index but cannot be found by path; such
+// collisions are listed by NameCollisions. SetNamer returns an error wrapping
+// ErrNameCollision if the tree already has collisions.
+//
+// Calling SetNamer with nil removes the names and the index.
+func (t *Tree[T]) SetNamer(name func(T) string) error {
+
+	if t.paths != nil {
+		t.paths.cancel()
+		t.paths = nil
+	}
+	if name == nil {
+		return nil
+	}
+
+	p := &pathIndex[T]{
+		name:     name,
+		children: map[uint]map[string][]uint{},
+		entries:  map[uint]pathEntry{},
+	}
+	if t.root != nil {
+		p.addSubtree(t.root)
+	}
+	p.cancel = t.watch(func(e Event[T]) { p.update(t, e) })
+	t.paths = p
+
+	if c := p.collisions(); len(c) > 0 {
+		return fmt.Errorf("%d names under node %d: %w", len(c[0].IDs), c[0].ParentID, ErrNameCollision)
+	}
+	return nil
+}
+
+// FindByPath finds a node by its path; see SetNamer. Empty path segments are
+// ignored, so that "a/b", "/a/b" and "/a/b/" are the same path. It returns an
+// error wrapping ErrNotFound if no node has the path or no namer is set, and
+// one wrapping ErrNameCollision if the path is ambiguous.
+func (t *Tree[T]) FindByPath(path string) (Node[T], error) {
+
+	if t.paths == nil || t.root == nil {
+		return nil, fmt.Errorf("path %q: %w", path, ErrNotFound)
+	}
+
+	n := t.root
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		ids := t.paths.children[n.GetID()][name]
+		switch len(ids) {
+		case 0:
+			return nil, fmt.Errorf("path %q: %w", path, ErrNotFound)
+		case 1:
+			n = t.primary.find(ids[0])
+		default:
+			return nil, fmt.Errorf("path %q: %d nodes named %q: %w", path, len(ids), name, ErrNameCollision)
+		}
+	}
+
+	return n, nil
+}
+
+// Path returns the path of a node; see SetNamer. It returns false if the node
+// is not in the tree or no namer is set.
+func (t *Tree[T]) Path(id uint) (string, bool) {
+
+	if t.paths == nil {
+		return "", false
+	}
+	n := t.primary.find(id)
+	if n == nil {
+		return "", false
+	}
+
+	names := []string{}
+	for ; n.GetParent() != nil; n = n.GetParent() {
+		names = append(names, t.paths.name(n.GetData()))
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+
+	return "/" + strings.Join(names, "/"), true
+}
+
+// NameCollisions lists the children of nodes that have the same name, ordered
+// by parent key and name. It returns nil if no namer is set.
+func (t *Tree[T]) NameCollisions() []NameCollision {
+	if t.paths == nil {
+		return nil
+	}
+	return t.paths.collisions()
+}
+
+func (p *pathIndex[T]) collisions() []NameCollision {
+
+	c := []NameCollision{}
+	for parentID, names := range p.children {
+		for name, ids := range names {
+			if len(ids) > 1 {
+				c = append(c, NameCollision{ParentID: parentID, Name: name, IDs: append([]uint{}, ids...)})
+			}
+		}
+	}
+
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].ParentID != c[j].ParentID {
+			return c[i].ParentID < c[j].ParentID
+		}
+		return c[i].Name < c[j].Name
+	})
+	return c
+}
+
+// update follows a change to the tree.
+func (p *pathIndex[T]) update(t *Tree[T], e Event[T]) {
+
+	n := e.Node
+
+	switch e.Type {
+	case NodeAdded, Merged:
+		// a node that becomes the root, when an addition that re-rooted the
+		// tree is undone, leaves the index as well
+		p.addSubtree(n)
+	case Rerooted:
+		// the former root joins the index as a child of the new root
+		for _, c := range n.GetChildren() {
+			p.add(c)
+		}
+	case Removed:
+		walk(n, p.remove)
+	case Moved:
+		p.add(n)
+	case DataChanged:
+		if t.primary.find(n.GetID()) == n { // not a node removed from the tree
+			p.add(n)
+		}
+	}
+}
+
+func (p *pathIndex[T]) addSubtree(n Node[T]) {
+	walk(n, p.add)
+}
+
+// add indexes a node under its current parent and name, replacing any entry
+// it had. The root is not indexed.
+func (p *pathIndex[T]) add(n Node[T]) {
+
+	p.remove(n)
+
+	parent := n.GetParent()
+	if parent == nil {
+		return
+	}
+
+	id, parentID, name := n.GetID(), parent.GetID(), p.name(n.GetData())
+	if p.children[parentID] == nil {
+		p.children[parentID] = map[string][]uint{}
+	}
+	p.children[parentID][name] = append(p.children[parentID][name], id)
+	p.entries[id] = pathEntry{parentID: parentID, name: name}
+}
+
+// remove removes the entry of a node from the index.
+func (p *pathIndex[T]) remove(n Node[T]) {
+
+	id := n.GetID()
+	e, ok := p.entries[id]
+	if !ok {
+		return
+	}
+	delete(p.entries, id)
+
+	names := p.children[e.parentID]
+	ids := names[e.name]
+	for i, c := range ids {
+		if c == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) > 0 {
+		names[e.name] = ids
+		return
+	}
+	delete(names, e.name)
+	if len(names) == 0 {
+		delete(p.children, e.parentID)
+	}
+}
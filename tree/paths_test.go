@@ -0,0 +1,191 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pathTestTree() *Tree[string] {
+	t := Empty[string]()
+	t.Add(1, 9, "company") // a holding company may be added as the root
+	t.Add(2, 1, "engineering")
+	t.Add(3, 2, "platform")
+	t.Add(4, 3, "storage")
+	t.Add(5, 1, "sales")
+	return t
+}
+
+// assertPathIndex checks that the index kept up to date by events matches an
+// index built from scratch.
+func assertPathIndex(t *testing.T, tree *Tree[string]) {
+	fresh := Empty[string]()
+	fresh.root = tree.root
+	fresh.primary = tree.primary
+	fresh.SetNamer(tree.paths.name)
+	assert.Equal(t, fresh.paths.children, tree.paths.children)
+	assert.Equal(t, fresh.paths.entries, tree.paths.entries)
+	fresh.paths.cancel()
+}
+
+func TestFindByPath(t *testing.T) {
+
+	tree := pathTestTree()
+	require.NoError(t, tree.SetNamer(func(s string) string { return s }))
+
+	var tests = map[string]struct {
+		path   string
+		expID  uint
+		expErr error
+	}{
+		"root":           {path: "/", expID: 1},
+		"empty":          {path: "", expID: 1},
+		"child":          {path: "/sales", expID: 5},
+		"deep":           {path: "/engineering/platform/storage", expID: 4},
+		"relative":       {path: "engineering/platform", expID: 3},
+		"trailing slash": {path: "/engineering/", expID: 2},
+		"missing":        {path: "/engineering/storage", expErr: ErrNotFound},
+		"root name":      {path: "/company", expErr: ErrNotFound},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			n, err := tree.FindByPath(tt.path)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expID, n.GetID())
+
+			path, ok := tree.Path(tt.expID)
+			assert.True(t, ok)
+			again, err := tree.FindByPath(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expID, again.GetID())
+		})
+	}
+
+	path, ok := tree.Path(4)
+	assert.True(t, ok)
+	assert.Equal(t, "/engineering/platform/storage", path)
+	_, ok = tree.Path(9)
+	assert.False(t, ok)
+}
+
+func TestPathIndexFollowsChanges(t *testing.T) {
+
+	var tests = map[string]struct {
+		change  func(*Tree[string])
+		expPath map[uint]string
+	}{
+		"add": {
+			change: func(t *Tree[string]) {
+				t.Add(6, 3, "compute")
+			},
+			expPath: map[uint]string{6: "/engineering/platform/compute"},
+		},
+		"re-root": {
+			change: func(t *Tree[string]) {
+				t.Add(9, 0, "holding")
+			},
+			expPath: map[uint]string{1: "/company", 4: "/company/engineering/platform/storage"},
+		},
+		"undo re-root": {
+			change: func(t *Tree[string]) {
+				t.EnableHistory(0)
+				t.Add(9, 0, "holding")
+				t.Undo()
+			},
+			expPath: map[uint]string{1: "/", 4: "/engineering/platform/storage"},
+		},
+		"merge": {
+			change: func(t *Tree[string]) {
+				other := Empty[string]()
+				other.Add(6, 5, "europe")
+				other.Add(7, 6, "france")
+				t.Merge(other)
+			},
+			expPath: map[uint]string{7: "/sales/europe/france"},
+		},
+		"rename": {
+			change: func(t *Tree[string]) {
+				n, _ := t.Find(2)
+				n.SetData("research")
+			},
+			expPath: map[uint]string{4: "/research/platform/storage"},
+		},
+		"move": {
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{{Type: OpMove, ID: 3, ParentID: 5}})
+			},
+			expPath: map[uint]string{4: "/sales/platform/storage"},
+		},
+		"remove": {
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{{Type: OpRemove, ID: 3}})
+			},
+			expPath: map[uint]string{2: "/engineering"},
+		},
+		"failed patch": {
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{
+					{Type: OpSetData, ID: 2, Data: "research"},
+					{Type: OpRemove, ID: 9},
+				})
+			},
+			expPath: map[uint]string{4: "/engineering/platform/storage"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := pathTestTree()
+			require.NoError(t, tree.SetNamer(func(s string) string { return s }))
+
+			tt.change(tree)
+			assertPathIndex(t, tree)
+
+			for id, exp := range tt.expPath {
+				path, ok := tree.Path(id)
+				assert.True(t, ok)
+				assert.Equal(t, exp, path)
+
+				n, err := tree.FindByPath(exp)
+				require.NoError(t, err)
+				assert.Equal(t, id, n.GetID())
+			}
+		})
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+
+	tree := pathTestTree()
+	require.NoError(t, tree.SetNamer(func(s string) string { return s }))
+
+	tree.Add(6, 1, "sales")
+	assert.Equal(t, []NameCollision{{ParentID: 1, Name: "sales", IDs: []uint{5, 6}}}, tree.NameCollisions())
+	_, err := tree.FindByPath("/sales")
+	assert.ErrorIs(t, err, ErrNameCollision)
+
+	// renaming one of the siblings resolves the collision
+	n, _ := tree.Find(6)
+	n.SetData("marketing")
+	assert.Empty(t, tree.NameCollisions())
+	assertPathIndex(t, tree)
+
+	// a collision in an existing tree is reported when the namer is set
+	tree.Add(7, 2, "platform")
+	err = tree.SetNamer(func(s string) string { return s })
+	assert.ErrorIs(t, err, ErrNameCollision)
+	assert.Len(t, tree.NameCollisions(), 1)
+
+	require.NoError(t, tree.SetNamer(nil))
+	assert.Nil(t, tree.NameCollisions())
+	_, err = tree.FindByPath("/sales")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, ok := tree.Path(2)
+	assert.False(t, ok)
+}
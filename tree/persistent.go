@@ -0,0 +1,331 @@
+package tree
+
+import "fmt"
+
+// Persistent is an immutable tree. Operations that modify a Persistent tree,
+// such as Add, Remove, Move and SetData, leave the tree unchanged and return
+// a new version of it instead. The new version shares every node that was not
+// changed with the old one, so that creating a version costs time and memory
+// in proportion to the depth of the index and the number of nodes changed,
+// not the size of the tree.
+//
+// Because versions are never modified, any number of goroutines may read a
+// version while another produces the next one, without locking.
+//
+// The nodes of a Persistent tree are held in a persistent map, keyed by
+// primary key, in place of the index of a Tree. Each node records the primary
+// keys of its parent and children rather than pointers to them. The Node
+// values returned by Find, FindParents, Root and Traverse resolve these keys
+// against the version they were returned from. Those nodes cannot be modified;
+// the methods of the Node interface that modify a node panic.
+//
+// The zero value is not usable; create an empty tree with EmptyPersistent.
+type Persistent[T any] struct {
+	root  uint
+	nodes pmap[*pnode[T]]
+}
+
+type pnode[T any] struct {
+	primary  uint
+	parentID uint
+	data     T
+	children []uint
+}
+
+// EmptyPersistent creates and returns an empty persistent tree.
+func EmptyPersistent[T any]() *Persistent[T] {
+	return &Persistent[T]{}
+}
+
+// Freeze creates a persistent tree holding the same nodes, with the same data
+// and the same order of children, as the tree. The tree is not modified.
+func (t *Tree[T]) Freeze() *Persistent[T] {
+
+	p := EmptyPersistent[T]()
+	if t.root == nil {
+		return p
+	}
+
+	p.root = t.root.GetID()
+	walk(t.root, func(n Node[T]) {
+		pn := &pnode[T]{primary: n.GetID(), parentID: n.GetParentID(), data: n.GetData()}
+		for _, c := range n.GetChildren() {
+			pn.children = append(pn.children, c.GetID())
+		}
+		p.nodes = p.nodes.set(pn.primary, pn)
+	})
+
+	return p
+}
+
+// Thaw creates a mutable Tree holding the same nodes, with the same data and
+// the same order of children, as this version of the persistent tree.
+func (p *Persistent[T]) Thaw() *Tree[T] {
+
+	t := Empty[T]()
+	for n := range p.Traverse(TraverseBreadthFirst) {
+		t.Add(n.GetID(), n.GetParentID(), n.GetData())
+	}
+
+	return t
+}
+
+// Len returns the number of nodes in the tree.
+func (p *Persistent[T]) Len() int {
+	return p.nodes.len()
+}
+
+// Root returns the root node of the tree. If the tree has no nodes, this
+// function returns nil.
+func (p *Persistent[T]) Root() Node[T] {
+	if p.nodes.len() == 0 {
+		return nil
+	}
+	return p.view(p.root)
+}
+
+// Find looks up a node by its primary key. If the node is found, then ok is
+// true and a Node is returned. If the node is not found, then ok is false and
+// a nil pointer is returned.
+func (p *Persistent[T]) Find(id uint) (n Node[T], ok bool) {
+	if _, found := p.nodes.get(id); !found {
+		return
+	}
+	return p.view(id), true
+}
+
+// FindParents finds the list of all parent nodes between a target node and the
+// root of the tree. See Tree.FindParents.
+func (p *Persistent[T]) FindParents(id uint) (parents []Node[T], ok bool) {
+
+	f, ok := p.Find(id)
+	if !ok {
+		return
+	}
+
+	for n := f.GetParent(); n != nil; n = n.GetParent() {
+		parents = append(parents, n)
+	}
+
+	return parents, true
+}
+
+// Traverse visits each node of the tree in a specified order. See
+// Tree.Traverse. Since the tree cannot change, the traversal always visits
+// every node of this version of the tree.
+func (p *Persistent[T]) Traverse(trvsl TraversalType) <-chan Node[T] {
+	return traverse(p.Root(), trvsl)
+}
+
+// Add returns a new version of the tree with an element inserted as a node.
+// The rules for insertion, including re-rooting, are those of Tree.Add.
+//
+// If the element cannot be inserted, the receiver is returned along with an
+// error: ErrExists if the primary key is already in the tree, ErrNotFound if
+// the parent is not in the tree, or ErrCycle if the element would be both the
+// parent of the root and a descendent of another node.
+func (p *Persistent[T]) Add(nodeID uint, parentID uint, data T) (*Persistent[T], error) {
+
+	if _, found := p.nodes.get(nodeID); found {
+		return p, fmt.Errorf("node %d: %w", nodeID, ErrExists)
+	}
+
+	child := &pnode[T]{primary: nodeID, parentID: parentID, data: data}
+	next := &Persistent[T]{root: p.root, nodes: p.nodes}
+
+	if p.nodes.len() == 0 { // always insert the first element
+		next.root = nodeID
+		next.nodes = next.nodes.set(nodeID, child)
+		return next, nil
+	}
+
+	root, _ := p.nodes.get(p.root)
+	parent, found := p.nodes.get(parentID)
+
+	switch {
+	case !found && root.parentID == nodeID: // incoming node is parent of root
+		child.children = []uint{p.root}
+		next.root = nodeID
+	case !found:
+		return p, fmt.Errorf("parent %d: %w", parentID, ErrNotFound)
+	case root.parentID == nodeID: // parent exists, but incoming node causes cycle
+		return p, fmt.Errorf("node %d: %w", nodeID, ErrCycle)
+	default:
+		next.nodes = next.nodes.set(parentID, parent.withChild(nodeID))
+	}
+
+	next.nodes = next.nodes.set(nodeID, child)
+	return next, nil
+}
+
+// Remove returns a new version of the tree without the node with the given
+// primary key and all of its descendents. Removing the root leaves an empty
+// tree. If the node is not found, the receiver is returned along with
+// ErrNotFound.
+func (p *Persistent[T]) Remove(id uint) (*Persistent[T], error) {
+
+	n, found := p.nodes.get(id)
+	if !found {
+		return p, fmt.Errorf("node %d: %w", id, ErrNotFound)
+	}
+	if id == p.root {
+		return EmptyPersistent[T](), nil
+	}
+
+	next := &Persistent[T]{root: p.root, nodes: p.nodes}
+
+	parent, _ := p.nodes.get(n.parentID)
+	next.nodes = next.nodes.set(parent.primary, parent.withoutChild(id))
+
+	var remove func(n *pnode[T])
+	remove = func(n *pnode[T]) {
+		next.nodes = next.nodes.delete(n.primary)
+		for _, c := range n.children {
+			cn, _ := p.nodes.get(c)
+			remove(cn)
+		}
+	}
+	remove(n)
+
+	return next, nil
+}
+
+// Move returns a new version of the tree in which the node with the given
+// primary key, along with its descendents, is a child of the node with
+// primary key parentID. The node becomes the last child of its new parent.
+//
+// If the node cannot be moved, the receiver is returned along with an error:
+// ErrNotFound if either node is not in the tree, ErrIsRoot if the node is the
+// root, or ErrCycle if the new parent is a descendent of the node.
+func (p *Persistent[T]) Move(id uint, parentID uint) (*Persistent[T], error) {
+
+	n, found := p.nodes.get(id)
+	if !found {
+		return p, fmt.Errorf("node %d: %w", id, ErrNotFound)
+	}
+	if id == p.root {
+		return p, fmt.Errorf("node %d: %w", id, ErrIsRoot)
+	}
+	if _, found := p.nodes.get(parentID); !found {
+		return p, fmt.Errorf("parent %d: %w", parentID, ErrNotFound)
+	}
+	for a := parentID; ; {
+		if a == id {
+			return p, fmt.Errorf("node %d beneath %d: %w", id, parentID, ErrCycle)
+		}
+		if a == p.root {
+			break
+		}
+		an, _ := p.nodes.get(a)
+		a = an.parentID
+	}
+
+	next := &Persistent[T]{root: p.root, nodes: p.nodes}
+
+	oldParent, _ := next.nodes.get(n.parentID)
+	next.nodes = next.nodes.set(oldParent.primary, oldParent.withoutChild(id))
+	parent, _ := next.nodes.get(parentID)
+	next.nodes = next.nodes.set(parentID, parent.withChild(id))
+
+	moved := *n
+	moved.parentID = parentID
+	next.nodes = next.nodes.set(id, &moved)
+
+	return next, nil
+}
+
+// SetData returns a new version of the tree in which the node with the given
+// primary key holds data. If the node is not found, the receiver is returned
+// along with ErrNotFound.
+func (p *Persistent[T]) SetData(id uint, data T) (*Persistent[T], error) {
+
+	n, found := p.nodes.get(id)
+	if !found {
+		return p, fmt.Errorf("node %d: %w", id, ErrNotFound)
+	}
+
+	changed := *n
+	changed.data = data
+
+	return &Persistent[T]{root: p.root, nodes: p.nodes.set(id, &changed)}, nil
+}
+
+// withChild returns a copy of the node with a child appended.
+func (n *pnode[T]) withChild(id uint) *pnode[T] {
+	c := *n
+	c.children = make([]uint, 0, len(n.children)+1)
+	c.children = append(c.children, n.children...)
+	c.children = append(c.children, id)
+	return &c
+}
+
+// withoutChild returns a copy of the node with a child removed.
+func (n *pnode[T]) withoutChild(id uint) *pnode[T] {
+	c := *n
+	c.children = make([]uint, 0, len(n.children))
+	for _, cid := range n.children {
+		if cid != id {
+			c.children = append(c.children, cid)
+		}
+	}
+	return &c
+}
+
+func (p *Persistent[T]) view(id uint) *pview[T] {
+	n, _ := p.nodes.get(id)
+	return &pview[T]{tree: p, n: n}
+}
+
+// pview implements the Node interface for a node of one version of a
+// persistent tree.
+type pview[T any] struct {
+	tree *Persistent[T]
+	n    *pnode[T]
+}
+
+func (v *pview[T]) GetID() uint {
+	return v.n.primary
+}
+
+func (v *pview[T]) GetParentID() uint {
+	return v.n.parentID
+}
+
+func (v *pview[T]) GetChildren() []Node[T] {
+	children := make([]Node[T], len(v.n.children))
+	for i, c := range v.n.children {
+		children[i] = v.tree.view(c)
+	}
+	return children
+}
+
+func (v *pview[T]) GetParent() Node[T] {
+	if v.n.primary == v.tree.root {
+		return nil
+	}
+	return v.tree.view(v.n.parentID)
+}
+
+func (v *pview[T]) GetData() T {
+	return v.n.data
+}
+
+func (v *pview[T]) AddChildren(...Node[T]) {
+	panic("tree: cannot add children to a node of a persistent tree; use Persistent.Add")
+}
+
+func (v *pview[T]) ReplaceChildren(...Node[T]) {
+	panic("tree: cannot replace children of a node of a persistent tree; use Persistent.Move")
+}
+
+func (v *pview[T]) SetData(T) {
+	panic("tree: cannot set data of a node of a persistent tree; use Persistent.SetData")
+}
+
+func (v *pview[T]) setParent(Node[T]) {
+	panic("tree: cannot set parent of a node of a persistent tree")
+}
+
+func (v *pview[T]) unsetParent() {
+	panic("tree: cannot unset parent of a node of a persistent tree")
+}
@@ -0,0 +1,227 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func persistentIDs[T any](p *Persistent[T], trvsl TraversalType) []uint {
+	ids := []uint{}
+	for n := range p.Traverse(trvsl) {
+		ids = append(ids, n.GetID())
+	}
+	return ids
+}
+
+func TestPersistentAdd(t *testing.T) {
+
+	var tests = map[string]struct {
+		adds   []addInput
+		expErr []error
+		expBFC []uint
+		expDFC []uint
+	}{
+		"three level multi-children": {
+			adds:   []addInput{{1, 0}, {2, 1}, {3, 2}, {4, 1}},
+			expErr: []error{nil, nil, nil, nil},
+			expBFC: []uint{1, 2, 4, 3},
+			expDFC: []uint{1, 2, 3, 4},
+		},
+		"re-root with a new subtree": {
+			adds:   []addInput{{1, 2}, {3, 1}, {2, 0}, {4, 2}},
+			expErr: []error{nil, nil, nil, nil},
+			expBFC: []uint{2, 1, 4, 3},
+			expDFC: []uint{2, 1, 3, 4},
+		},
+		"re-root with cycle": {
+			adds:   []addInput{{1, 2}, {3, 1}, {2, 3}},
+			expErr: []error{nil, nil, ErrCycle},
+			expBFC: []uint{1, 3},
+			expDFC: []uint{1, 3},
+		},
+		"failed inserts": {
+			adds:   []addInput{{1, 0}, {2, 1}, {3, 2}, {2, 1}, {4, 5}},
+			expErr: []error{nil, nil, nil, ErrExists, ErrNotFound},
+			expBFC: []uint{1, 2, 3},
+			expDFC: []uint{1, 2, 3},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := EmptyPersistent[int]()
+			for i, input := range tt.adds {
+				next, err := p.Add(input.nodeID, input.parentID, 0)
+				if tt.expErr[i] != nil {
+					assert.ErrorIs(t, err, tt.expErr[i])
+					assert.Same(t, p, next)
+				} else {
+					assert.NoError(t, err)
+				}
+				p = next
+			}
+
+			assert.Equal(t, tt.expBFC, persistentIDs(p, TraverseBreadthFirst))
+			assert.Equal(t, tt.expDFC, persistentIDs(p, TraverseDepthFirst))
+			assert.Equal(t, len(tt.expBFC), p.Len())
+		})
+	}
+}
+
+func TestPersistentOperations(t *testing.T) {
+
+	var tests = map[string]struct {
+		op      func(p *Persistent[string]) (*Persistent[string], error)
+		expErr  error
+		expBFC  []uint
+		expDFC  []uint
+		expData map[uint]string
+	}{
+		"remove leaf": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Remove(4) },
+			expBFC: []uint{1, 2, 5, 3},
+			expDFC: []uint{1, 2, 3, 5},
+		},
+		"remove subtree": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Remove(2) },
+			expBFC: []uint{1, 5},
+			expDFC: []uint{1, 5},
+		},
+		"remove root": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Remove(1) },
+			expBFC: []uint{},
+			expDFC: []uint{},
+		},
+		"remove missing": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Remove(9) },
+			expErr: ErrNotFound,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"move": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Move(2, 5) },
+			expBFC: []uint{1, 5, 2, 3, 4},
+			expDFC: []uint{1, 5, 2, 3, 4},
+		},
+		"move within parent": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Move(3, 2) },
+			expBFC: []uint{1, 2, 5, 4, 3},
+			expDFC: []uint{1, 2, 4, 3, 5},
+		},
+		"move root": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Move(1, 5) },
+			expErr: ErrIsRoot,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"move beneath descendent": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Move(2, 3) },
+			expErr: ErrCycle,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"move to missing parent": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.Move(2, 9) },
+			expErr: ErrNotFound,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"set data": {
+			op:      func(p *Persistent[string]) (*Persistent[string], error) { return p.SetData(3, "THREE") },
+			expBFC:  []uint{1, 2, 5, 3, 4},
+			expDFC:  []uint{1, 2, 3, 4, 5},
+			expData: map[uint]string{3: "THREE"},
+		},
+		"set data missing": {
+			op:     func(p *Persistent[string]) (*Persistent[string], error) { return p.SetData(9, "nine") },
+			expErr: ErrNotFound,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			orig := patchTestTree().Freeze()
+			got, gotErr := tt.op(orig)
+
+			if tt.expErr != nil {
+				assert.ErrorIs(t, gotErr, tt.expErr)
+			} else {
+				assert.NoError(t, gotErr)
+			}
+
+			assert.Equal(t, tt.expBFC, persistentIDs(got, TraverseBreadthFirst))
+			assert.Equal(t, tt.expDFC, persistentIDs(got, TraverseDepthFirst))
+			assert.Equal(t, len(tt.expBFC), got.Len())
+			for id, data := range tt.expData {
+				n, _ := got.Find(id)
+				assert.Equal(t, data, n.GetData())
+			}
+
+			// the original version is unchanged
+			assert.True(t, Equal(patchTestTree(), orig.Thaw(), nil, true))
+		})
+	}
+}
+
+func TestPersistentFind(t *testing.T) {
+
+	p := patchTestTree().Freeze()
+
+	n, ok := p.Find(3)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint(3), n.GetID())
+		assert.Equal(t, uint(2), n.GetParentID())
+		assert.Equal(t, "three", n.GetData())
+		assert.Equal(t, uint(2), n.GetParent().GetID())
+	}
+
+	_, ok = p.Find(9)
+	assert.False(t, ok)
+
+	parents, ok := p.FindParents(4)
+	assert.True(t, ok)
+	ids := []uint{}
+	for _, n := range parents {
+		ids = append(ids, n.GetID())
+	}
+	assert.Equal(t, []uint{2, 1}, ids)
+
+	parents, ok = p.FindParents(1)
+	assert.True(t, ok)
+	assert.Empty(t, parents)
+
+	_, ok = p.FindParents(9)
+	assert.False(t, ok)
+
+	assert.Nil(t, EmptyPersistent[string]().Root())
+	assert.Nil(t, p.Root().GetParent())
+}
+
+func TestPersistentFreezeThaw(t *testing.T) {
+
+	tree := Empty[string]()
+	tree.Add(1, 2, "one")
+	tree.Add(3, 1, "three")
+	tree.Add(4, 1, "four")
+	tree.Add(2, 0, "two")
+
+	p := tree.Freeze()
+	assert.Equal(t, 4, p.Len())
+	assert.Equal(t, uint(2), p.Root().GetID())
+	assert.True(t, Equal(tree, p.Thaw(), nil, true))
+
+	assert.True(t, Equal(Empty[string](), EmptyPersistent[string]().Thaw(), nil, true))
+}
+
+func TestPersistentImmutableNodes(t *testing.T) {
+
+	p := patchTestTree().Freeze()
+	n, _ := p.Find(2)
+
+	assert.Panics(t, func() { n.SetData("TWO") })
+	assert.Panics(t, func() { n.AddChildren() })
+	assert.Panics(t, func() { n.ReplaceChildren() })
+}
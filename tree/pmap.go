@@ -0,0 +1,187 @@
+package tree
+
+import "math/bits"
+
+// pmap is a persistent map from primary keys to values, implemented as a hash
+// array mapped trie. Every modification returns a new map that shares all of
+// its unchanged branches with the original; the original is never modified.
+// The zero value is an empty map.
+//
+// Primary keys are used as their own hash. Each level of the trie consumes
+// five bits of the key, so a lookup visits at most thirteen levels.
+type pmap[V any] struct {
+	root *pmapNode[V]
+	size int
+}
+
+type pmapNode[V any] struct {
+	bitmap  uint32
+	entries []pmapEntry[V]
+}
+
+// pmapEntry is either a leaf holding a key and value, or, if sub is not nil,
+// a branch to the next level of the trie.
+type pmapEntry[V any] struct {
+	key   uint
+	value V
+	sub   *pmapNode[V]
+}
+
+const pmapBits = 5
+
+func pmapSlot(key uint, shift uint) (bit uint32) {
+	return 1 << ((key >> shift) & (1<<pmapBits - 1))
+}
+
+func (n *pmapNode[V]) position(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (m pmap[V]) len() int {
+	return m.size
+}
+
+func (m pmap[V]) get(key uint) (v V, ok bool) {
+	n := m.root
+	for shift := uint(0); n != nil; shift += pmapBits {
+		bit := pmapSlot(key, shift)
+		if n.bitmap&bit == 0 {
+			return
+		}
+		e := n.entries[n.position(bit)]
+		if e.sub == nil {
+			if e.key == key {
+				return e.value, true
+			}
+			return
+		}
+		n = e.sub
+	}
+	return
+}
+
+// set returns a map in which key is associated with v.
+func (m pmap[V]) set(key uint, v V) pmap[V] {
+	root, added := m.root.set(0, key, v)
+	if added {
+		m.size++
+	}
+	m.root = root
+	return m
+}
+
+func (n *pmapNode[V]) set(shift uint, key uint, v V) (*pmapNode[V], bool) {
+
+	if n == nil {
+		n = &pmapNode[V]{}
+	}
+
+	bit := pmapSlot(key, shift)
+	i := n.position(bit)
+
+	c := &pmapNode[V]{bitmap: n.bitmap | bit}
+
+	if n.bitmap&bit == 0 {
+		c.entries = make([]pmapEntry[V], 0, len(n.entries)+1)
+		c.entries = append(c.entries, n.entries[:i]...)
+		c.entries = append(c.entries, pmapEntry[V]{key: key, value: v})
+		c.entries = append(c.entries, n.entries[i:]...)
+		return c, true
+	}
+
+	c.entries = make([]pmapEntry[V], len(n.entries))
+	copy(c.entries, n.entries)
+
+	e := n.entries[i]
+	added := false
+	switch {
+	case e.sub != nil:
+		c.entries[i].sub, added = e.sub.set(shift+pmapBits, key, v)
+	case e.key == key:
+		c.entries[i].value = v
+	default:
+		// two keys share this slot; push both down a level
+		sub, _ := (*pmapNode[V])(nil).set(shift+pmapBits, e.key, e.value)
+		sub, _ = sub.set(shift+pmapBits, key, v)
+		c.entries[i] = pmapEntry[V]{sub: sub}
+		added = true
+	}
+
+	return c, added
+}
+
+// delete returns a map in which key is not present.
+func (m pmap[V]) delete(key uint) pmap[V] {
+	root, removed := m.root.delete(0, key)
+	if removed {
+		m.size--
+		m.root = root
+	}
+	return m
+}
+
+func (n *pmapNode[V]) delete(shift uint, key uint) (*pmapNode[V], bool) {
+
+	if n == nil {
+		return nil, false
+	}
+
+	bit := pmapSlot(key, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	i := n.position(bit)
+	e := n.entries[i]
+
+	var replace *pmapEntry[V]
+	if e.sub == nil {
+		if e.key != key {
+			return n, false
+		}
+	} else {
+		sub, removed := e.sub.delete(shift+pmapBits, key)
+		if !removed {
+			return n, false
+		}
+		if sub != nil {
+			replace = &pmapEntry[V]{sub: sub}
+			if len(sub.entries) == 1 && sub.entries[0].sub == nil {
+				// collapse a branch holding a single leaf
+				replace = &sub.entries[0]
+			}
+		}
+	}
+
+	if replace != nil {
+		c := &pmapNode[V]{bitmap: n.bitmap, entries: make([]pmapEntry[V], len(n.entries))}
+		copy(c.entries, n.entries)
+		c.entries[i] = *replace
+		return c, true
+	}
+
+	if len(n.entries) == 1 {
+		return nil, true
+	}
+	c := &pmapNode[V]{bitmap: n.bitmap &^ bit, entries: make([]pmapEntry[V], 0, len(n.entries)-1)}
+	c.entries = append(c.entries, n.entries[:i]...)
+	c.entries = append(c.entries, n.entries[i+1:]...)
+	return c, true
+}
+
+// each calls f for every key and value in the map, in no particular order.
+func (m pmap[V]) each(f func(key uint, v V)) {
+	m.root.each(f)
+}
+
+func (n *pmapNode[V]) each(f func(key uint, v V)) {
+	if n == nil {
+		return
+	}
+	for _, e := range n.entries {
+		if e.sub != nil {
+			e.sub.each(f)
+		} else {
+			f(e.key, e.value)
+		}
+	}
+}
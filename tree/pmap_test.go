@@ -0,0 +1,118 @@
+package tree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPmapSetGet(t *testing.T) {
+
+	var tests = map[string]struct {
+		keys []uint
+	}{
+		"empty": {
+			keys: []uint{},
+		},
+		"distinct slots": {
+			keys: []uint{1, 2, 3, 31},
+		},
+		"shared low bits": {
+			keys: []uint{1, 33, 1025, 1 << 40, 1<<40 + 1},
+		},
+		"full width": {
+			keys: []uint{^uint(0), ^uint(0) >> 1, 1 << 63, 1<<63 + 1},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var m pmap[uint]
+			for _, k := range tt.keys {
+				m = m.set(k, k*2)
+			}
+			// setting again replaces without changing the size
+			for _, k := range tt.keys {
+				m = m.set(k, k*3)
+			}
+
+			assert.Equal(t, len(tt.keys), m.len())
+			for _, k := range tt.keys {
+				v, ok := m.get(k)
+				assert.True(t, ok, "key %d", k)
+				assert.Equal(t, k*3, v)
+			}
+			_, ok := m.get(12345)
+			assert.False(t, ok)
+
+			got := map[uint]uint{}
+			m.each(func(k uint, v uint) { got[k] = v })
+			assert.Len(t, got, len(tt.keys))
+		})
+	}
+}
+
+func TestPmapDelete(t *testing.T) {
+
+	var m pmap[int]
+	for _, k := range []uint{1, 33, 65, 2, 1 << 40} {
+		m = m.set(k, int(k))
+	}
+
+	m = m.delete(99)
+	assert.Equal(t, 5, m.len())
+
+	m = m.delete(33)
+	assert.Equal(t, 4, m.len())
+	_, ok := m.get(33)
+	assert.False(t, ok)
+	for _, k := range []uint{1, 65, 2, 1 << 40} {
+		_, ok := m.get(k)
+		assert.True(t, ok, "key %d", k)
+	}
+
+	for _, k := range []uint{1, 65, 2, 1 << 40} {
+		m = m.delete(k)
+	}
+	assert.Equal(t, 0, m.len())
+	assert.Nil(t, m.root)
+}
+
+func TestPmapPersistence(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+
+	var m pmap[int]
+	ref := map[uint]int{}
+	versions := []pmap[int]{}
+	refs := []map[uint]int{}
+
+	for i := 0; i < 2000; i++ {
+		k := uint(r.Intn(500))
+		if r.Intn(3) == 0 {
+			m = m.delete(k)
+			delete(ref, k)
+		} else {
+			m = m.set(k, i)
+			ref[k] = i
+		}
+
+		if i%100 == 0 {
+			versions = append(versions, m)
+			cp := map[uint]int{}
+			for k, v := range ref {
+				cp[k] = v
+			}
+			refs = append(refs, cp)
+		}
+	}
+
+	// every old version still holds exactly its own contents
+	for i, v := range versions {
+		assert.Equal(t, len(refs[i]), v.len())
+		got := map[uint]int{}
+		v.each(func(k uint, val int) { got[k] = val })
+		assert.Equal(t, refs[i], got)
+	}
+}
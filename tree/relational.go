@@ -0,0 +1,205 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NestedSetRow is a node of a tree in the nested-set model. The Left and Right
+// values of a node enclose those of all of its descendents, so that the
+// descendents of a node are the rows whose Left falls between its own Left and
+// Right. Depth is zero for the root.
+type NestedSetRow struct {
+	ID    uint
+	Left  int
+	Right int
+	Depth int
+}
+
+// ClosureRow is a row of the closure table of a tree: one row links every node
+// to each of its ancestors, and to itself at distance zero.
+type ClosureRow struct {
+	Ancestor   uint
+	Descendant uint
+	Distance   int
+}
+
+// walkDepthFirst visits the nodes of the tree in depth first order, calling
+// enter with each node and the path of its ancestors, from the root down to
+// its parent, and exit once all of its descendents have been visited.
+func (t *Tree[T]) walkDepthFirst(enter func(n Node[T], ancestors []Node[T]), exit func(n Node[T])) {
+
+	path := []Node[T]{}
+
+	for n := range t.Traverse(TraverseDepthFirst) {
+		for len(path) > 0 && path[len(path)-1].GetID() != n.GetParentID() {
+			exit(path[len(path)-1])
+			path = path[:len(path)-1]
+		}
+		enter(n, path)
+		path = append(path, n)
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		exit(path[i])
+	}
+}
+
+// NestedSet returns the rows of the tree in the nested-set model, in depth
+// first order. Left and Right values are numbered from one.
+func (t *Tree[T]) NestedSet() []NestedSetRow {
+
+	rows := []NestedSetRow{}
+	pos := map[uint]int{}
+	counter := 0
+
+	t.walkDepthFirst(
+		func(n Node[T], ancestors []Node[T]) {
+			counter++
+			pos[n.GetID()] = len(rows)
+			rows = append(rows, NestedSetRow{ID: n.GetID(), Left: counter, Depth: len(ancestors)})
+		},
+		func(n Node[T]) {
+			counter++
+			rows[pos[n.GetID()]].Right = counter
+		},
+	)
+
+	return rows
+}
+
+// ClosureTable returns the closure table of the tree. The rows of each node
+// are in depth first order of the node, starting with the row linking the node
+// to itself and followed by its ancestors from the nearest.
+func (t *Tree[T]) ClosureTable() []ClosureRow {
+
+	rows := []ClosureRow{}
+
+	t.walkDepthFirst(
+		func(n Node[T], ancestors []Node[T]) {
+			id := n.GetID()
+			rows = append(rows, ClosureRow{Ancestor: id, Descendant: id})
+			for i := len(ancestors) - 1; i >= 0; i-- {
+				rows = append(rows, ClosureRow{
+					Ancestor:   ancestors[i].GetID(),
+					Descendant: id,
+					Distance:   len(ancestors) - i,
+				})
+			}
+		},
+		func(Node[T]) {},
+	)
+
+	return rows
+}
+
+// FromNestedSet builds a tree from rows in the nested-set model, in any order.
+// The data of each node is given by data; if data is nil, nodes hold the zero
+// value of T. An error wrapping ErrFormat is returned if the rows do not
+// describe a single tree.
+//
+// The rows do not record the parent ID of the root, so the root of the tree
+// built always has parent ID 0. A tree whose root has another parent ID does
+// not compare Equal to the tree rebuilt from its NestedSet.
+func FromNestedSet[T any](rows []NestedSetRow, data func(id uint) T) (*Tree[T], error) {
+
+	sorted := append([]NestedSetRow{}, rows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Left < sorted[j].Left })
+
+	t := Empty[T]()
+	open := []NestedSetRow{} // the ancestors of the current row
+
+	for i, r := range sorted {
+		if r.Left >= r.Right {
+			return nil, fmt.Errorf("nested set row %d: left %d not before right %d: %w", r.ID, r.Left, r.Right, ErrFormat)
+		}
+
+		for len(open) > 0 && open[len(open)-1].Right < r.Left {
+			open = open[:len(open)-1]
+		}
+
+		var parentID uint
+		if len(open) > 0 {
+			parent := open[len(open)-1]
+			if r.Right > parent.Right {
+				return nil, fmt.Errorf("nested set row %d overlaps row %d: %w", r.ID, parent.ID, ErrFormat)
+			}
+			parentID = parent.ID
+		} else if i > 0 {
+			return nil, fmt.Errorf("nested set row %d is a second root: %w", r.ID, ErrFormat)
+		}
+
+		if err := addRow(t, r.ID, parentID, data); err != nil {
+			return nil, err
+		}
+		open = append(open, r)
+	}
+
+	return t, nil
+}
+
+// FromClosureTable builds a tree from the rows of a closure table, in any
+// order. Only the rows at distances zero and one determine the shape of the
+// tree. Siblings are added in the order in which their rows are found, so
+// that rows from ClosureTable rebuild the tree with its children in order.
+// The data of each node is given by data; if data is nil, nodes hold the zero
+// value of T. An error wrapping ErrFormat is returned if the rows do not
+// describe a single tree.
+//
+// As with FromNestedSet, the root of the tree built always has parent ID 0.
+func FromClosureTable[T any](rows []ClosureRow, data func(id uint) T) (*Tree[T], error) {
+
+	parents := map[uint]uint{}
+	depths := map[uint]int{}
+	ids := []uint{}
+
+	for _, r := range rows {
+		for _, id := range []uint{r.Ancestor, r.Descendant} {
+			if _, ok := depths[id]; !ok {
+				ids = append(ids, id)
+				depths[id] = 0
+			}
+		}
+		if r.Distance > depths[r.Descendant] {
+			depths[r.Descendant] = r.Distance
+		}
+		if r.Distance == 1 {
+			if p, ok := parents[r.Descendant]; ok && p != r.Ancestor {
+				return nil, fmt.Errorf("closure table node %d has two parents: %w", r.Descendant, ErrFormat)
+			}
+			parents[r.Descendant] = r.Ancestor
+		}
+	}
+
+	// every node is added after its parent, which is nearer to the root
+	sort.SliceStable(ids, func(i, j int) bool { return depths[ids[i]] < depths[ids[j]] })
+
+	t := Empty[T]()
+	for i, id := range ids {
+		parentID, ok := parents[id]
+		if !ok && i > 0 {
+			return nil, fmt.Errorf("closure table node %d is a second root: %w", id, ErrFormat)
+		}
+		if err := addRow(t, id, parentID, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func addRow[T any](t *Tree[T], id uint, parentID uint, data func(id uint) T) error {
+
+	var d T
+	if data != nil {
+		d = data(id)
+	}
+
+	if added, exists := t.Add(id, parentID, d); !added {
+		if exists {
+			return fmt.Errorf("node %d: %w", id, ErrExists)
+		}
+		return fmt.Errorf("node %d: parent %d %w", id, parentID, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package tree
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNestedSet(t *testing.T) {
+
+	var tests = map[string]struct {
+		prep    func() *Tree[string]
+		expRows []NestedSetRow
+	}{
+		"empty": {
+			prep:    Empty[string],
+			expRows: []NestedSetRow{},
+		},
+		"tree": {
+			prep: patchTestTree,
+			expRows: []NestedSetRow{
+				{ID: 1, Left: 1, Right: 10, Depth: 0},
+				{ID: 2, Left: 2, Right: 7, Depth: 1},
+				{ID: 3, Left: 3, Right: 4, Depth: 2},
+				{ID: 4, Left: 5, Right: 6, Depth: 2},
+				{ID: 5, Left: 8, Right: 9, Depth: 1},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := tt.prep()
+			rows := tree.NestedSet()
+			assert.Equal(t, tt.expRows, rows)
+
+			// rows in reverse order rebuild the same tree
+			reversed := []NestedSetRow{}
+			for i := len(rows) - 1; i >= 0; i-- {
+				reversed = append(reversed, rows[i])
+			}
+			got, err := FromNestedSet(reversed, func(id uint) string {
+				n, _ := tree.Find(id)
+				return n.GetData()
+			})
+			require.NoError(t, err)
+			assert.True(t, Equal(tree, got, nil, true))
+		})
+	}
+}
+
+func TestClosureTable(t *testing.T) {
+
+	var tests = map[string]struct {
+		prep    func() *Tree[string]
+		expRows []ClosureRow
+	}{
+		"empty": {
+			prep:    Empty[string],
+			expRows: []ClosureRow{},
+		},
+		"tree": {
+			prep: patchTestTree,
+			expRows: []ClosureRow{
+				{1, 1, 0},
+				{2, 2, 0}, {1, 2, 1},
+				{3, 3, 0}, {2, 3, 1}, {1, 3, 2},
+				{4, 4, 0}, {2, 4, 1}, {1, 4, 2},
+				{5, 5, 0}, {1, 5, 1},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := tt.prep()
+			rows := tree.ClosureTable()
+			assert.Equal(t, tt.expRows, rows)
+
+			reversed := []ClosureRow{}
+			for i := len(rows) - 1; i >= 0; i-- {
+				reversed = append(reversed, rows[i])
+			}
+			data := func(id uint) string {
+				n, _ := tree.Find(id)
+				return n.GetData()
+			}
+
+			// a closure table does not order siblings; they are added in the
+			// order their rows are found
+			got, err := FromClosureTable(reversed, data)
+			require.NoError(t, err)
+			assert.True(t, Equal(tree, got, nil, false))
+
+			got, err = FromClosureTable(rows, data)
+			require.NoError(t, err)
+			assert.True(t, Equal(tree, got, nil, true))
+		})
+	}
+}
+
+func TestRelationalLargeTree(t *testing.T) {
+
+	tree := Empty[string]()
+	tree.Add(1, 0, "1")
+	for i := uint(2); i <= 100; i++ {
+		tree.Add(i, i/3+1, strconv.Itoa(int(i)))
+	}
+	data := func(id uint) string { return strconv.Itoa(int(id)) }
+
+	got, err := FromNestedSet(tree.NestedSet(), data)
+	require.NoError(t, err)
+	assert.True(t, Equal(tree, got, nil, true))
+
+	got, err = FromClosureTable(tree.ClosureTable(), data)
+	require.NoError(t, err)
+	assert.True(t, Equal(tree, got, nil, true))
+}
+
+func TestRelationalRootParent(t *testing.T) {
+
+	// the parent ID of the root is not recorded in the rows
+	tree := Empty[string]()
+	tree.Add(1, 9, "1")
+	tree.Add(2, 1, "2")
+
+	got, err := FromNestedSet[string](tree.NestedSet(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), got.Root().GetParentID())
+	assert.False(t, Equal(tree, got, nil, true))
+
+	got, err = FromClosureTable[string](tree.ClosureTable(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), got.Root().GetParentID())
+	assert.False(t, Equal(tree, got, nil, true))
+}
+
+func TestFromNestedSetInvalid(t *testing.T) {
+
+	var tests = map[string][]NestedSetRow{
+		"right before left": {{ID: 1, Left: 2, Right: 1}},
+		"two roots":         {{ID: 1, Left: 1, Right: 2}, {ID: 2, Left: 3, Right: 4}},
+		"overlap":           {{ID: 1, Left: 1, Right: 6}, {ID: 2, Left: 2, Right: 4}, {ID: 3, Left: 3, Right: 5}},
+	}
+
+	for name, rows := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromNestedSet[string](rows, nil)
+			assert.ErrorIs(t, err, ErrFormat)
+		})
+	}
+
+	_, err := FromNestedSet[string]([]NestedSetRow{{ID: 1, Left: 1, Right: 4}, {ID: 1, Left: 2, Right: 3}}, nil)
+	assert.ErrorIs(t, err, ErrExists)
+}
+
+func TestFromClosureTableInvalid(t *testing.T) {
+
+	var tests = map[string][]ClosureRow{
+		"two parents": {{1, 1, 0}, {2, 2, 0}, {3, 3, 0}, {1, 3, 1}, {2, 3, 1}},
+		"two roots":   {{1, 1, 0}, {2, 2, 0}},
+	}
+
+	for name, rows := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromClosureTable[string](rows, nil)
+			assert.ErrorIs(t, err, ErrFormat)
+		})
+	}
+}
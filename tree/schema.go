@@ -0,0 +1,89 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// FormatVersion is the version of the stream format written by SerializeWith.
+// Streams written by Serialize have no header, and are at format version zero.
+const FormatVersion = 1
+
+// Header describes a stream written by SerializeWith. It is the first line of
+// the stream.
+type Header struct {
+	// Format is the version of the stream format.
+	Format int
+	// Schema is the version of the node data, as given to RegisterSchema or
+	// SerializeOptions.
+	Schema int
+	// Created is the time at which the stream was written.
+	Created time.Time
+	// Nodes is the number of nodes in the stream.
+	Nodes int
+	// Checksum is the digest written in the trailer of the stream, if any.
+	Checksum ChecksumType `json:",omitempty"`
+}
+
+// Migration converts the data of a node, encoded by an earlier version of the
+// schema of T, to T.
+type Migration[T any] func(old json.RawMessage, fromVersion int) (T, error)
+
+type schema struct {
+	version int
+	migrate interface{} // a Migration[T]
+}
+
+// schemas holds the registered schema of each type of node data.
+var schemas sync.Map
+
+// RegisterSchema sets the current schema version of the node data type T.
+// SerializeWith writes it in the header of the stream, unless another version
+// is given in its options. When Deserialize reads a stream written with an
+// older version, the data of every node is converted with migrate; if migrate
+// is nil, the data is decoded as it is. Streams with a newer version are
+// rejected with an error wrapping ErrFormat.
+//
+// Streams without a header, such as those written by Serialize, are at schema
+// version zero.
+func RegisterSchema[T any](version int, migrate Migration[T]) {
+	schemas.Store(typeOf[T](), schema{version: version, migrate: migrate})
+}
+
+// schemaOf returns the registered schema version and migration of T. It
+// returns false if no schema is registered.
+func schemaOf[T any]() (int, Migration[T], bool) {
+	s, ok := schemas.Load(typeOf[T]())
+	if !ok {
+		return 0, nil, false
+	}
+	m, _ := s.(schema).migrate.(Migration[T])
+	return s.(schema).version, m, true
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// ReadHeader reads the header of a serialized tree, decompressing the stream
+// if needed. For a stream without a header, such as one written by
+// Serialize, it returns a Header at format version zero with only the Format
+// and Schema fields set.
+func ReadHeader(stream io.Reader) (Header, error) {
+
+	frames, err := newFrameReader[json.RawMessage](stream)
+	if err != nil {
+		return Header{}, fmt.Errorf("error reading header: %w", err)
+	}
+
+	h, err := frames.readHeader()
+	if err != nil {
+		return Header{}, fmt.Errorf("error reading header: %w", err)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,249 @@
+package tree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHeader(t *testing.T) {
+
+	var tests = map[string]struct {
+		stream    func() (io.ReadCloser, <-chan error)
+		expFormat int
+		expSchema int
+		expNodes  int
+	}{
+		"unversioned": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return patchTestTree().Serialize(TraverseBreadthFirst)
+			},
+		},
+		"versioned": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return patchTestTree().SerializeWith(TraverseBreadthFirst, SerializeOptions{Schema: 3})
+			},
+			expFormat: FormatVersion,
+			expSchema: 3,
+			expNodes:  5,
+		},
+		"compressed": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return patchTestTree().SerializeWith(TraverseBreadthFirst, SerializeOptions{Gzip: true})
+			},
+			expFormat: FormatVersion,
+			expNodes:  5,
+		},
+		"empty": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return Empty[string]().SerializeWith(TraverseBreadthFirst, SerializeOptions{})
+			},
+			expFormat: FormatVersion,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rdr, _ := tt.stream()
+			data, err := io.ReadAll(rdr)
+			require.NoError(t, err)
+
+			h, err := ReadHeader(bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expFormat, h.Format)
+			assert.Equal(t, tt.expSchema, h.Schema)
+			assert.Equal(t, tt.expNodes, h.Nodes)
+			if tt.expFormat > 0 {
+				assert.WithinDuration(t, time.Now(), h.Created, time.Minute)
+			}
+		})
+	}
+}
+
+type personV1 struct {
+	Name string
+}
+
+type personV2 struct {
+	First string
+	Last  string
+}
+
+func migratePerson(old json.RawMessage, fromVersion int) (personV2, error) {
+	var p personV1
+	if err := json.Unmarshal(old, &p); err != nil {
+		return personV2{}, err
+	}
+	if p.Name == "" {
+		return personV2{}, errors.New("no name")
+	}
+	first, last, _ := strings.Cut(p.Name, " ")
+	return personV2{First: first, Last: last}, nil
+}
+
+func TestDeserializeMigration(t *testing.T) {
+
+	RegisterSchema[personV2](2, migratePerson)
+
+	old := Empty[personV1]()
+	old.Add(1, 0, personV1{"Ada Lovelace"})
+	old.Add(2, 1, personV1{"Alan Turing"})
+
+	exp := Empty[personV2]()
+	exp.Add(1, 0, personV2{"Ada", "Lovelace"})
+	exp.Add(2, 1, personV2{"Alan", "Turing"})
+
+	var tests = map[string]struct {
+		stream func() (io.ReadCloser, <-chan error)
+		exp    *Tree[personV2]
+		expErr error
+	}{
+		"unversioned": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return old.Serialize(TraverseBreadthFirst)
+			},
+			exp: exp,
+		},
+		"older schema": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return old.SerializeWith(TraverseBreadthFirst, SerializeOptions{Schema: 1, Checksum: ChecksumCRC32})
+			},
+			exp: exp,
+		},
+		"current schema": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return exp.SerializeWith(TraverseBreadthFirst, SerializeOptions{})
+			},
+			exp: exp,
+		},
+		"newer schema": {
+			stream: func() (io.ReadCloser, <-chan error) {
+				return exp.SerializeWith(TraverseBreadthFirst, SerializeOptions{Schema: 3})
+			},
+			expErr: ErrFormat,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rdr, _ := tt.stream()
+			got, err := Deserialize[personV2](rdr)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, Equal(tt.exp, got, nil, true))
+		})
+	}
+
+	// the current schema is written by default
+	rdr, _ := exp.SerializeWith(TraverseBreadthFirst, SerializeOptions{})
+	h, err := ReadHeader(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Schema)
+
+	// errors from the migration are returned
+	bad := Empty[personV1]()
+	bad.Add(1, 0, personV1{})
+	rdr, _ = bad.Serialize(TraverseBreadthFirst)
+	_, err = Deserialize[personV2](rdr)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no name")
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+
+	// data written by the package for its own use is at the current schema,
+	// and must not be migrated again when read back
+	RegisterSchema[personV2](2, migratePerson)
+	ctx := context.Background()
+
+	exp := Empty[personV2]()
+	exp.Add(1, 0, personV2{"Ada", "Lovelace"})
+	exp.Add(2, 1, personV2{"Alan", "Turing"})
+
+	var tests = map[string]func(t *testing.T) *Tree[personV2]{
+		"store": func(t *testing.T) *Tree[personV2] {
+			s := &MemStore{}
+			_, err := exp.Save(ctx, s, "people", 0)
+			require.NoError(t, err)
+			got, _, err := Load[personV2](ctx, s, "people")
+			require.NoError(t, err)
+			return got
+		},
+		"log store": func(t *testing.T) *Tree[personV2] {
+			dir := t.TempDir()
+			s, err := OpenLogStore[personV2](dir, LogOptions{CompactEvery: 1})
+			require.NoError(t, err)
+			s.Tree().Add(1, 0, personV2{"Ada", "Lovelace"})
+			s.Tree().Add(2, 1, personV2{"Alan", "Turing"})
+			require.NoError(t, s.Close())
+
+			again, err := OpenLogStore[personV2](dir, LogOptions{})
+			require.NoError(t, err)
+			defer again.Close()
+			return again.Tree()
+		},
+		"lazy subtree": func(t *testing.T) *Tree[personV2] {
+			var buf bytes.Buffer
+			require.NoError(t, exp.WriteSeekable(&buf))
+			l, err := OpenLazy[personV2](bytes.NewReader(buf.Bytes()), int64(buf.Len()), 0)
+			require.NoError(t, err)
+			got, err := l.Subtree(1)
+			require.NoError(t, err)
+			return got
+		},
+	}
+
+	for name, read := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, Equal(exp, read(t), nil, true))
+		})
+	}
+}
+
+func TestDeserializeHeader(t *testing.T) {
+
+	rdr, _ := patchTestTree().SerializeWith(TraverseBreadthFirst, SerializeOptions{})
+	data, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	var tests = map[string]struct {
+		data   []byte
+		expErr error
+	}{
+		"missing node": {
+			data:   bytes.Join(lines[:len(lines)-2], nil),
+			expErr: ErrTruncated,
+		},
+		"extra node": {
+			data:   append(append([]byte{}, data...), `{"Primary":9,"ParentID":1,"Data":"nine"}`+"\n"...),
+			expErr: ErrCorrupt,
+		},
+		"second header": {
+			data:   append(append([]byte{}, data...), lines[0]...),
+			expErr: ErrCorrupt,
+		},
+		"newer format": {
+			data:   []byte(`{"Header":{"Format":99}}` + "\n"),
+			expErr: ErrFormat,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Deserialize[string](io.NopCloser(bytes.NewReader(tt.data)))
+			assert.ErrorIs(t, err, tt.expErr)
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package tree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrConflict indicates that a blob in a Store was not at the generation
+// required by a conditional write.
+var ErrConflict = errors.New("generation mismatch")
+
+// AnyGeneration makes a write to a Store unconditional.
+const AnyGeneration int64 = -1
+
+// Store keeps blobs of data by name, such as serialized trees.
+//
+// Every write of a blob gives it a new generation number, greater than zero,
+// that can be used for optimistic concurrency control: a writer reads a blob
+// along with its generation, and its write succeeds only if no other write has
+// happened in the meantime. The argument ifGeneration of Put and Delete is the
+// generation the blob must be at for the write to happen: AnyGeneration makes
+// the write unconditional, and zero requires that the blob does not exist.
+// When the blob is at a different generation, an error wrapping ErrConflict
+// is returned.
+//
+// Reading a blob that does not exist returns an error wrapping ErrNotFound. A
+// blob is replaced atomically; readers see either the whole of the old data or
+// the whole of the new data.
+type Store interface {
+	// Put writes the data read from r as the blob with the given name and
+	// returns its new generation.
+	Put(ctx context.Context, name string, r io.Reader, ifGeneration int64) (int64, error)
+	// Get opens the blob with the given name, and returns its generation.
+	Get(ctx context.Context, name string) (io.ReadCloser, int64, error)
+	// List returns the names of all blobs that start with prefix, in order.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the blob with the given name.
+	Delete(ctx context.Context, name string, ifGeneration int64) error
+}
+
+// Save serializes the tree as the blob with the given name in a Store and
+// returns the new generation of the blob. See Store for the meaning of
+// ifGeneration.
+func (t *Tree[T]) Save(ctx context.Context, s Store, name string, ifGeneration int64) (int64, error) {
+
+	// the tree is serialized in full before writing, so that a serialization
+	// error cannot leave a partial blob in the store
+	var buf bytes.Buffer
+	if err := t.serializeTo(&buf, TraverseBreadthFirst); err != nil {
+		return 0, fmt.Errorf("error saving tree %q: %w", name, err)
+	}
+
+	gen, err := s.Put(ctx, name, &buf, ifGeneration)
+	if err != nil {
+		return 0, fmt.Errorf("error saving tree %q: %w", name, err)
+	}
+
+	return gen, nil
+}
+
+// Load reads a tree saved with Tree.Save from a Store, and returns it along
+// with the generation of its blob.
+func Load[T any](ctx context.Context, s Store, name string) (*Tree[T], int64, error) {
+
+	rdr, gen, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error loading tree %q: %w", name, err)
+	}
+	defer rdr.Close()
+
+	t, err := Deserialize[T](rdr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error loading tree %q: %w", name, err)
+	}
+
+	return t, gen, nil
+}
+
+// MemStore is a Store that keeps blobs in memory. The zero value is an empty
+// store ready to use. A MemStore is safe for concurrent use.
+type MemStore struct {
+	mu    sync.Mutex
+	blobs map[string]memBlob
+	gen   int64
+}
+
+type memBlob struct {
+	data []byte
+	gen  int64
+}
+
+// Put implements Store.
+func (m *MemStore) Put(ctx context.Context, name string, r io.Reader, ifGeneration int64) (int64, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkGeneration(name, m.blobs[name].gen, ifGeneration); err != nil {
+		return 0, err
+	}
+
+	if m.blobs == nil {
+		m.blobs = map[string]memBlob{}
+	}
+	m.gen++
+	m.blobs[name] = memBlob{data: data, gen: m.gen}
+
+	return m.gen, nil
+}
+
+// Get implements Store.
+func (m *MemStore) Get(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.blobs[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("blob %q: %w", name, ErrNotFound)
+	}
+
+	return io.NopCloser(bytes.NewReader(b.data)), b.gen, nil
+}
+
+// List implements Store.
+func (m *MemStore) List(ctx context.Context, prefix string) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := []string{}
+	for name := range m.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Delete implements Store.
+func (m *MemStore) Delete(ctx context.Context, name string, ifGeneration int64) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.blobs[name]
+	if !ok {
+		return fmt.Errorf("blob %q: %w", name, ErrNotFound)
+	}
+	if err := checkGeneration(name, b.gen, ifGeneration); err != nil {
+		return err
+	}
+
+	delete(m.blobs, name)
+	return nil
+}
+
+// DirStore is a Store that keeps each blob as a file in a directory. A blob is
+// written to a temporary file that is then linked into place under a name
+// holding its generation, so that a write interrupted by a crash leaves the
+// previous generation intact, and two writers, even in different processes,
+// cannot both succeed in writing the same generation. Older generations are
+// removed once a new one is in place.
+//
+// Deleting a blob takes a generation of its own, kept as an empty directory in
+// place of the file, so that the generations of a blob written again after it
+// was deleted carry on from where they were. A writer holding a generation of
+// the blob from before it was deleted cannot then mistake the new blob for the
+// one it read. These directories are left in place for the life of the store.
+type DirStore struct {
+	dir string
+}
+
+const (
+	dirStoreSuffix = ".blob"
+	dirStoreTemp   = ".tmp"
+)
+
+// OpenDirStore returns a DirStore keeping its blobs in directory dir, creating
+// the directory if it does not exist.
+func OpenDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error opening store: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// Put implements Store.
+func (d *DirStore) Put(ctx context.Context, name string, r io.Reader, ifGeneration int64) (int64, error) {
+
+	tmp, err := os.CreateTemp(d.dir, "*"+dirStoreTemp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	for {
+		gens, err := d.generations(name)
+		if err != nil {
+			return 0, err
+		}
+		top := latest(gens)
+		if err := checkGeneration(name, top.current(), ifGeneration); err != nil {
+			return 0, err
+		}
+
+		// linking fails if the file exists, that is if another writer has
+		// already written the next generation
+		next := top.gen + 1
+		if err := os.Link(tmp.Name(), d.path(name, next)); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				if ifGeneration == AnyGeneration {
+					continue
+				}
+				return 0, fmt.Errorf("blob %q: %w", name, ErrConflict)
+			}
+			return 0, err
+		}
+
+		if err := d.settle(name, next); err != nil {
+			if errors.Is(err, ErrConflict) && ifGeneration == AnyGeneration {
+				continue
+			}
+			return 0, err
+		}
+
+		return next, nil
+	}
+}
+
+// Get implements Store.
+func (d *DirStore) Get(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		gens, err := d.generations(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		gen := latest(gens).current()
+		if gen == 0 {
+			return nil, 0, fmt.Errorf("blob %q: %w", name, ErrNotFound)
+		}
+
+		f, err := os.Open(d.path(name, gen))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // replaced by a newer generation since it was listed
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, gen, nil
+	}
+}
+
+// List implements Store.
+func (d *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tops := map[string]dirGeneration{}
+	for _, e := range entries {
+		name, gen, ok := parseBlobFile(e.Name())
+		if ok && strings.HasPrefix(name, prefix) && gen > tops[name].gen {
+			tops[name] = dirGeneration{gen: gen, deleted: e.IsDir()}
+		}
+	}
+
+	names := []string{}
+	for name, top := range tops {
+		if !top.deleted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Delete implements Store.
+func (d *DirStore) Delete(ctx context.Context, name string, ifGeneration int64) error {
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		gens, err := d.generations(name)
+		if err != nil {
+			return err
+		}
+		top := latest(gens)
+		if top.current() == 0 {
+			return fmt.Errorf("blob %q: %w", name, ErrNotFound)
+		}
+		if err := checkGeneration(name, top.current(), ifGeneration); err != nil {
+			return err
+		}
+
+		// the deletion takes the next generation the way a write does, so
+		// that it fails if another writer has already taken it
+		next := top.gen + 1
+		if err := os.Mkdir(d.path(name, next), 0o755); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				if ifGeneration == AnyGeneration {
+					continue
+				}
+				return fmt.Errorf("blob %q: %w", name, ErrConflict)
+			}
+			return err
+		}
+
+		if err := d.settle(name, next); err != nil {
+			if errors.Is(err, ErrConflict) && ifGeneration == AnyGeneration {
+				continue
+			}
+			return err
+		}
+
+		return nil
+	}
+}
+
+// settle completes a write that has just taken generation gen of a blob, by
+// removing the older generations. A writer that was slow to take its
+// generation may find that other writers have moved the blob on past it, and
+// had removed the generation it took as an old one. Its write is then undone,
+// and an error wrapping ErrConflict is returned.
+func (d *DirStore) settle(name string, gen int64) error {
+
+	gens, err := d.generations(name)
+	if err != nil {
+		return err
+	}
+	if latest(gens).gen > gen {
+		os.Remove(d.path(name, gen))
+		return fmt.Errorf("blob %q moved on past generation %d: %w", name, gen, ErrConflict)
+	}
+
+	for _, g := range gens {
+		if g.gen < gen {
+			os.Remove(d.path(name, g.gen))
+		}
+	}
+
+	return nil
+}
+
+// dirGeneration is a generation of a blob in a DirStore, which is either a
+// file holding the blob or the directory left by its deletion.
+type dirGeneration struct {
+	gen     int64
+	deleted bool
+}
+
+// current returns the generation of the blob if it exists, and zero if it has
+// never been written or was deleted.
+func (g dirGeneration) current() int64 {
+	if g.deleted {
+		return 0
+	}
+	return g.gen
+}
+
+// generations lists the generations of a blob that have files or directories.
+func (d *DirStore) generations(name string) ([]dirGeneration, error) {
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	gens := []dirGeneration{}
+	for _, e := range entries {
+		if n, gen, ok := parseBlobFile(e.Name()); ok && n == name {
+			gens = append(gens, dirGeneration{gen: gen, deleted: e.IsDir()})
+		}
+	}
+
+	return gens, nil
+}
+
+func (d *DirStore) path(name string, gen int64) string {
+	return filepath.Join(d.dir, url.PathEscape(name)+"."+strconv.FormatInt(gen, 10)+dirStoreSuffix)
+}
+
+// parseBlobFile extracts the name and generation of a blob from the name of
+// its file.
+func parseBlobFile(file string) (name string, gen int64, ok bool) {
+
+	if !strings.HasSuffix(file, dirStoreSuffix) {
+		return
+	}
+	file = strings.TrimSuffix(file, dirStoreSuffix)
+
+	dot := strings.LastIndexByte(file, '.')
+	if dot < 0 {
+		return
+	}
+	gen, err := strconv.ParseInt(file[dot+1:], 10, 64)
+	if err != nil || gen <= 0 {
+		return
+	}
+	name, err = url.PathUnescape(file[:dot])
+	if err != nil {
+		return
+	}
+
+	return name, gen, true
+}
+
+// latest returns the newest of the generations of a blob, or the zero
+// generation if there are none.
+func latest(gens []dirGeneration) dirGeneration {
+	var max dirGeneration
+	for _, g := range gens {
+		if g.gen > max.gen {
+			max = g
+		}
+	}
+	return max
+}
+
+// checkGeneration checks the generation of a blob, zero if it does not exist,
+// against the generation required by a write.
+func checkGeneration(name string, current int64, ifGeneration int64) error {
+	if ifGeneration != AnyGeneration && ifGeneration != current {
+		return fmt.Errorf("blob %q at generation %d, not %d: %w", name, current, ifGeneration, ErrConflict)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package tree
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	d, err := OpenDirStore(filepath.Join(t.TempDir(), "store"))
+	require.NoError(t, err)
+	return map[string]Store{
+		"memory":    &MemStore{},
+		"directory": d,
+	}
+}
+
+func readBlob(t *testing.T, s Store, name string) (string, int64) {
+	rdr, gen, err := s.Get(context.Background(), name)
+	require.NoError(t, err)
+	defer rdr.Close()
+	data, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	return string(data), gen
+}
+
+func TestStore(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			_, _, err := s.Get(ctx, "a/one")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			gen1, err := s.Put(ctx, "a/one", strings.NewReader("first"), 0)
+			require.NoError(t, err)
+			assert.Greater(t, gen1, int64(0))
+
+			_, err = s.Put(ctx, "a/one", strings.NewReader("again"), 0)
+			assert.ErrorIs(t, err, ErrConflict, "create existing")
+
+			gen2, err := s.Put(ctx, "a/one", strings.NewReader("second"), gen1)
+			require.NoError(t, err)
+			assert.Greater(t, gen2, gen1)
+
+			_, err = s.Put(ctx, "a/one", strings.NewReader("stale"), gen1)
+			assert.ErrorIs(t, err, ErrConflict, "stale generation")
+
+			data, gen := readBlob(t, s, "a/one")
+			assert.Equal(t, "second", data)
+			assert.Equal(t, gen2, gen)
+
+			gen3, err := s.Put(ctx, "a/one", strings.NewReader("third"), AnyGeneration)
+			require.NoError(t, err)
+			assert.Greater(t, gen3, gen2)
+
+			for _, n := range []string{"a/two", "b", "a.1"} {
+				_, err = s.Put(ctx, n, strings.NewReader(n), AnyGeneration)
+				require.NoError(t, err)
+			}
+			names, err := s.List(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a.1", "a/one", "a/two"}, names)
+			names, err = s.List(ctx, "")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a.1", "a/one", "a/two", "b"}, names)
+
+			assert.ErrorIs(t, s.Delete(ctx, "a/one", gen2), ErrConflict)
+			assert.NoError(t, s.Delete(ctx, "a/one", gen3))
+			assert.ErrorIs(t, s.Delete(ctx, "a/one", AnyGeneration), ErrNotFound)
+			_, _, err = s.Get(ctx, "a/one")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err = s.Put(cancelled, "c", strings.NewReader("c"), AnyGeneration)
+			assert.ErrorIs(t, err, context.Canceled)
+			_, _, err = s.Get(cancelled, "b")
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func TestStoreConcurrentWrites(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			gen, err := s.Put(ctx, "tree", strings.NewReader("0"), 0)
+			require.NoError(t, err)
+
+			// of several writers holding the same generation, only one wins
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			wins, conflicts := 0, 0
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := s.Put(ctx, "tree", strings.NewReader("1"), gen)
+					mu.Lock()
+					defer mu.Unlock()
+					if err == nil {
+						wins++
+					} else if errors.Is(err, ErrConflict) {
+						conflicts++
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, 1, wins)
+			assert.Equal(t, 7, conflicts)
+		})
+	}
+}
+
+func TestStoreConditionalWrites(t *testing.T) {
+
+	ctx := context.Background()
+
+	// each step is a write to blob "x", conditional on the generation it held
+	// at the step given by from, or on none or any
+	type step struct {
+		del  bool
+		from int // index of an earlier step, or -1 for zero, -2 for any
+		err  error
+	}
+	var tests = map[string][]step{
+		"create twice":             {{from: -1}, {from: -1, err: ErrConflict}},
+		"update":                   {{from: -1}, {from: 0}, {from: 1}},
+		"stale update":             {{from: -1}, {from: 0}, {from: 0, err: ErrConflict}},
+		"delete":                   {{from: -1}, {del: true, from: 0}, {del: true, from: -2, err: ErrNotFound}},
+		"stale delete":             {{from: -1}, {from: 0}, {del: true, from: 0, err: ErrConflict}},
+		"create after delete":      {{from: -1}, {del: true, from: 0}, {from: -1}},
+		"update after delete":      {{from: -1}, {del: true, from: 0}, {from: 0, err: ErrConflict}},
+		"update after re-creation": {{from: -1}, {del: true, from: 0}, {from: -1}, {from: 0, err: ErrConflict}},
+		"delete after re-creation": {{from: -1}, {del: true, from: 0}, {from: -1}, {del: true, from: 0, err: ErrConflict}},
+		"unconditional":            {{from: -2}, {del: true, from: -2}, {from: -2}, {from: -2}},
+	}
+
+	for name, steps := range tests {
+		t.Run(name, func(t *testing.T) {
+			for store, s := range testStores(t) {
+				t.Run(store, func(t *testing.T) {
+
+					gens := make([]int64, len(steps))
+					var last int64
+					for i, st := range steps {
+						ifGen := AnyGeneration
+						if st.from == -1 {
+							ifGen = 0
+						} else if st.from >= 0 {
+							ifGen = gens[st.from]
+						}
+
+						var err error
+						if st.del {
+							err = s.Delete(ctx, "x", ifGen)
+						} else {
+							gens[i], err = s.Put(ctx, "x", strings.NewReader(name), ifGen)
+						}
+
+						if st.err != nil {
+							assert.ErrorIs(t, err, st.err, "step %d", i)
+							continue
+						}
+						require.NoError(t, err, "step %d", i)
+						if !st.del {
+							// generations never go back, even across a deletion
+							assert.Greater(t, gens[i], last, "step %d", i)
+							last = gens[i]
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+
+	ctx := context.Background()
+
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			tree := patchTestTree()
+			gen, err := tree.Save(ctx, s, "trees/test", 0)
+			require.NoError(t, err)
+
+			got, loadedGen, err := Load[string](ctx, s, "trees/test")
+			require.NoError(t, err)
+			assert.Equal(t, gen, loadedGen)
+			assert.True(t, Equal(tree, got, nil, true))
+
+			// a concurrent change between load and save is detected
+			got.Add(6, 5, "six")
+			_, err = tree.Save(ctx, s, "trees/test", gen)
+			require.NoError(t, err)
+			_, err = got.Save(ctx, s, "trees/test", loadedGen)
+			assert.ErrorIs(t, err, ErrConflict)
+
+			_, _, err = Load[string](ctx, s, "trees/missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// a tree that cannot be serialized leaves the blob untouched
+			bad := Empty[func()]()
+			bad.Add(1, 0, func() {})
+			_, err = bad.Save(ctx, s, "trees/test", AnyGeneration)
+			assert.Error(t, err)
+			_, _, err = Load[string](ctx, s, "trees/test")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDirStoreInterruptedWrite(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := OpenDirStore(dir)
+	require.NoError(t, err)
+
+	gen, err := s.Put(ctx, "tree", strings.NewReader("complete"), 0)
+	require.NoError(t, err)
+
+	// a temporary file left by a crashed writer is ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "123.tmp"), []byte("part"), 0o644))
+
+	data, got := readBlob(t, s, "tree")
+	assert.Equal(t, "complete", data)
+	assert.Equal(t, gen, got)
+	names, err := s.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tree"}, names)
+
+	// only the current generation is kept
+	_, err = s.Put(ctx, "tree", strings.NewReader("newer"), gen)
+	require.NoError(t, err)
+	matches, err := filepath.Glob(filepath.Join(dir, "*.blob"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 1)
+}
+
+func TestDirStoreStaleWrite(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := OpenDirStore(dir)
+	require.NoError(t, err)
+
+	gen, err := s.Put(ctx, "tree", strings.NewReader("first"), 0)
+	require.NoError(t, err)
+
+	// a writer that took generation gen+1 after others had written gen+1 and
+	// gen+2, and removed gen+1 as an old generation, is undone
+	require.NoError(t, os.WriteFile(s.path("tree", gen+2), []byte("third"), 0o644))
+	require.NoError(t, os.WriteFile(s.path("tree", gen+1), []byte("stale"), 0o644))
+	assert.ErrorIs(t, s.settle("tree", gen+1), ErrConflict)
+
+	data, got := readBlob(t, s, "tree")
+	assert.Equal(t, "third", data)
+	assert.Equal(t, gen+2, got)
+	_, err = os.Stat(s.path("tree", gen+1))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
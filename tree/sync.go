@@ -0,0 +1,161 @@
+package tree
+
+import (
+	"io"
+	"sync"
+)
+
+// SyncTree is a tree that is safe for concurrent use by multiple goroutines.
+// It wraps a Tree, guarding every operation with a read-write mutex; any
+// number of readers may use the tree at once, while writers have exclusive
+// access.
+//
+// A Tree itself holds no locks. Its index is a plain map, and traversal reads
+// the children of nodes from another goroutine, so a Tree that is modified
+// while it is read by another goroutine is subject to data races. Share a
+// SyncTree instead.
+//
+// The nodes returned by Root, Find and FindParents are the nodes of the
+// wrapped tree. Reading their primary keys is always safe, but their links and
+// data may be changed by writers; modifying them directly, through the Node
+// interface, bypasses the lock. Use View and Update for anything that must
+// inspect or modify nodes consistently.
+type SyncTree[T any] struct {
+	mu   sync.RWMutex
+	tree *Tree[T]
+}
+
+// NewSync wraps a tree for concurrent use. If t is nil, the wrapper holds an
+// empty tree. The tree must not be used other than through the wrapper
+// afterwards.
+func NewSync[T any](t *Tree[T]) *SyncTree[T] {
+	if t == nil {
+		t = Empty[T]()
+	}
+	return &SyncTree[T]{tree: t}
+}
+
+// Root returns the root node of the tree, or nil if the tree has no nodes.
+func (s *SyncTree[T]) Root() Node[T] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Root()
+}
+
+// Add inserts an element into the tree as a node. See Tree.Add.
+func (s *SyncTree[T]) Add(nodeID uint, parentID uint, data T) (added bool, exists bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Add(nodeID, parentID, data)
+}
+
+// Merge another tree into this tree. See Tree.Merge. The other tree is not
+// guarded by this tree's lock and must not be used concurrently with the
+// merge.
+func (s *SyncTree[T]) Merge(other *Tree[T]) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Merge(other)
+}
+
+// SetData replaces the data of the node with the given primary key. If the
+// node is not found, ok is false.
+func (s *SyncTree[T]) SetData(id uint, data T) (ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.tree.primary.find(id)
+	if n == nil {
+		return false
+	}
+	n.SetData(data)
+	return true
+}
+
+// Apply performs every operation of a patch on the tree atomically. See
+// Tree.Apply.
+func (s *SyncTree[T]) Apply(p Patch[T]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Apply(p)
+}
+
+// Batch runs f as a transaction on the tree while holding the write lock. See
+// Tree.Batch.
+func (s *SyncTree[T]) Batch(f func(tx *Tx[T]) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Batch(f)
+}
+
+// Find looks up a node by its primary key. See Tree.Find.
+func (s *SyncTree[T]) Find(id uint) (n Node[T], ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Find(id)
+}
+
+// FindParents finds the list of all parent nodes between a target node and
+// the root of the tree. See Tree.FindParents.
+func (s *SyncTree[T]) FindParents(id uint) (parents []Node[T], ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindParents(id)
+}
+
+// Snapshot returns a copy of the tree as it is at the time of the call. The
+// copy shares no nodes with the tree and may be read or modified freely. The
+// data of each node is copied with copyData, as in Tree.Clone.
+func (s *SyncTree[T]) Snapshot(copyData func(T) T) *Tree[T] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Clone(copyData)
+}
+
+// Traverse visits each node of the tree in a specified order. See
+// Tree.Traverse.
+//
+// Unlike Tree.Traverse, the traversal is consistent: it visits the nodes of a
+// snapshot of the tree taken at the time of the call, so that writers are not
+// blocked while the caller consumes the traversal, and changes they make are
+// not seen by it. Node data is copied into the snapshot by assignment.
+func (s *SyncTree[T]) Traverse(trvsl TraversalType) <-chan Node[T] {
+	return s.Snapshot(nil).Traverse(trvsl)
+}
+
+// Serialize encodes a snapshot of the tree as a byte stream. See
+// Tree.Serialize.
+func (s *SyncTree[T]) Serialize(trvsl TraversalType) (io.ReadCloser, <-chan error) {
+	return s.Snapshot(nil).Serialize(trvsl)
+}
+
+// Subscribe registers a function that is called with every event emitted by
+// the tree. See Tree.Subscribe. f is called while the write lock is held, and
+// must not call any method of the SyncTree.
+func (s *SyncTree[T]) Subscribe(f func(Event[T])) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.tree.Subscribe(f)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c()
+	}
+}
+
+// View calls f with the wrapped tree while holding a read lock. f must not
+// modify the tree, and must not retain it or any of its nodes after it
+// returns.
+func (s *SyncTree[T]) View(f func(t *Tree[T])) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f(s.tree)
+}
+
+// Update calls f with the wrapped tree while holding the write lock, so that
+// several operations may be performed as one. f must not retain the tree or
+// any of its nodes after it returns.
+func (s *SyncTree[T]) Update(f func(t *Tree[T])) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.tree)
+}
@@ -0,0 +1,155 @@
+package tree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSync(t *testing.T) {
+
+	var tests = map[string]struct {
+		tree   *Tree[int]
+		expBFC []uint
+	}{
+		"nil tree": {
+			tree:   nil,
+			expBFC: []uint{},
+		},
+		"existing tree": {
+			tree: func() *Tree[int] {
+				t := Empty[int]()
+				t.Add(1, 0, 1)
+				t.Add(2, 1, 2)
+				return t
+			}(),
+			expBFC: []uint{1, 2},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := NewSync(tt.tree)
+			assert.Equal(t, tt.expBFC, bfc([]Node[int]{s.Root()}, []uint{}))
+		})
+	}
+}
+
+func TestSyncTreeOperations(t *testing.T) {
+
+	s := NewSync[string](nil)
+
+	added, _ := s.Add(1, 0, "one")
+	assert.True(t, added)
+	added, _ = s.Add(2, 1, "two")
+	assert.True(t, added)
+	_, exists := s.Add(2, 1, "two")
+	assert.True(t, exists)
+
+	other := Empty[string]()
+	other.Add(3, 2, "three")
+	assert.True(t, s.Merge(other))
+
+	assert.True(t, s.SetData(3, "THREE"))
+	assert.False(t, s.SetData(4, "four"))
+
+	n, ok := s.Find(3)
+	if assert.True(t, ok) {
+		assert.Equal(t, "THREE", n.GetData())
+	}
+
+	parents, ok := s.FindParents(3)
+	assert.True(t, ok)
+	assert.Len(t, parents, 2)
+
+	assert.NoError(t, s.Apply(Patch[string]{{Type: OpMove, ID: 3, ParentID: 1}}))
+
+	got := []uint{}
+	for n := range s.Traverse(TraverseDepthFirst) {
+		got = append(got, n.GetID())
+	}
+	assert.Equal(t, []uint{1, 2, 3}, got)
+
+	s.Update(func(tree *Tree[string]) {
+		tree.Add(4, 2, "four")
+		tree.Add(5, 4, "five")
+	})
+	s.View(func(tree *Tree[string]) {
+		assert.Equal(t, []uint{1, 2, 3, 4, 5}, bfc([]Node[string]{tree.root}, []uint{}))
+	})
+}
+
+func TestSyncTreeSnapshot(t *testing.T) {
+
+	s := NewSync[int](nil)
+	s.Add(1, 0, 1)
+	s.Add(2, 1, 2)
+
+	iter := s.Traverse(TraverseBreadthFirst)
+	snap := s.Snapshot(nil)
+
+	// changes after the traversal has started are not visited
+	s.Add(3, 1, 3)
+	s.SetData(2, 20)
+
+	got := []int{}
+	for n := range iter {
+		got = append(got, n.GetData())
+	}
+	assert.Equal(t, []int{1, 2}, got)
+	assert.Equal(t, []uint{1, 2}, bfc([]Node[int]{snap.root}, []uint{}))
+}
+
+// TestSyncTreeConcurrent is intended to be run with the -race flag.
+func TestSyncTreeConcurrent(t *testing.T) {
+
+	const writers = 4
+	const perWriter = 250
+
+	s := NewSync[int](nil)
+	s.Add(1, 0, 0)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			base := uint(2 + w*perWriter)
+			s.Add(base, 1, w)
+			for i := uint(1); i < perWriter; i++ {
+				s.Add(base+i, base+i-1, int(i))
+				s.SetData(base, int(i))
+			}
+		}(w)
+	}
+
+	for r := 0; r < writers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id := uint(2 + r*perWriter + i)
+				if n, ok := s.Find(id); ok {
+					assert.Equal(t, id, n.GetID())
+				}
+				s.FindParents(id)
+				if i%50 == 0 {
+					for n := range s.Traverse(TraversalType(i % 100 / 50)) {
+						n.GetData()
+						n.GetChildren()
+					}
+				}
+			}
+		}(r)
+	}
+
+	wg.Wait()
+
+	count := 0
+	for range s.Traverse(TraverseBreadthFirst) {
+		count++
+	}
+	assert.Equal(t, 1+writers*perWriter, count)
+}
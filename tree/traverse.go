@@ -1,7 +1,7 @@
 package tree
 
 import (
-	"github.com/phf/go-queue/queue"
+	"github.com/kingledion/go-tools/queue"
 )
 
 // TraversalType determines the order in which an operation is performed on a tree.
@@ -31,19 +31,27 @@ const (
 // added after its correct place in traversal order will not be visited, nor
 // will any of its children.
 func (t *Tree[T]) Traverse(trvsl TraversalType) <-chan Node[T] {
+	return traverse(t.root, trvsl)
+}
+
+// traverse visits root and all of its descendents in the specified order.
+func traverse[T any](root Node[T], trvsl TraversalType) <-chan Node[T] {
 	search := make(chan Node[T])
 
 	switch trvsl {
 	case TraverseBreadthFirst:
-		q := queue.New()
-		q.PushBack(t.root)
 		go func() {
-			for {
-				if bfs(q, search) {
-					close(search)
-					break
-				}
+			if root != nil {
+				bfs(root, search)
+			}
+			close(search)
+		}()
+	case TraverseDepthFirst:
+		go func() {
+			if root != nil {
+				dfs(root, search)
 			}
+			close(search)
 		}()
 	}
 
@@ -51,21 +59,56 @@ func (t *Tree[T]) Traverse(trvsl TraversalType) <-chan Node[T] {
 
 }
 
-func bfs[T any](q *queue.Queue, search chan<- Node[T]) bool {
+// TraverseBestFirst visits each node of a tree, returning those nodes to an
+// iterator-like channel as Traverse does, in best first order: of all nodes
+// whose parent has been visited, the next node visited is the one of highest
+// priority, as given by less. The function less reports whether a has a
+// higher priority than b.
+//
+// The root is visited first. If less orders nodes by a cost that never
+// decreases from a parent to its children, nodes are visited in order of cost.
+func (t *Tree[T]) TraverseBestFirst(less func(a, b Node[T]) bool) <-chan Node[T] {
+	search := make(chan Node[T])
 
-	current := q.PopFront()
-	switch c := current.(type) {
-	case Node[T]:
-		for _, c := range c.GetChildren() {
+	go func() {
+		if t.root != nil {
+			q := queue.NewPriority(less)
+			q.Push(t.root)
+			for {
+				n, ok := q.Pop()
+				if !ok {
+					break
+				}
+				for _, c := range n.GetChildren() {
+					q.Push(c)
+				}
+				search <- n
+			}
+		}
+		close(search)
+	}()
+
+	return search
+}
+
+func bfs[T any](root Node[T], search chan<- Node[T]) {
+	var q queue.Deque[Node[T]]
+	q.PushBack(root)
+	for {
+		n, ok := q.PopFront()
+		if !ok {
+			return
+		}
+		for _, c := range n.GetChildren() {
 			q.PushBack(c)
 		}
-		search <- c
-		return false
-	case nil:
-		return true
-	default:
-		// Should be unreachable...
-		return true
+		search <- n
 	}
+}
 
+func dfs[T any](n Node[T], search chan<- Node[T]) {
+	search <- n
+	for _, c := range n.GetChildren() {
+		dfs(c, search)
+	}
 }
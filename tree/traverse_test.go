@@ -6,7 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestBFS(t *testing.T) {
+func TestTraverse(t *testing.T) {
 
 	tests := map[string]struct {
 		tree      func() *Tree[int]
@@ -26,6 +26,24 @@ func TestBFS(t *testing.T) {
 			traversal: TraverseBreadthFirst,
 			expSearch: []uint{1, 2, 3, 6, 4, 5},
 		},
+		"depth first": {
+			tree: func() *Tree[int] {
+				node6 := &node[int]{primary: 6}
+				node5 := &node[int]{primary: 5}
+				node4 := &node[int]{primary: 4}
+				node3 := &node[int]{primary: 3, children: []Node[int]{node4, node5}}
+				node2 := &node[int]{primary: 2, children: []Node[int]{node6}}
+				node1 := &node[int]{primary: 1, children: []Node[int]{node2, node3}}
+				return &Tree[int]{root: node1}
+			},
+			traversal: TraverseDepthFirst,
+			expSearch: []uint{1, 2, 6, 3, 4, 5},
+		},
+		"depth first empty": {
+			tree:      Empty[int],
+			traversal: TraverseDepthFirst,
+			expSearch: []uint{},
+		},
 	}
 
 	for name, tt := range tests {
@@ -35,7 +53,72 @@ func TestBFS(t *testing.T) {
 				assert.Equal(t, tt.expSearch[i], g.GetID())
 				i = i + 1
 			}
+			assert.Equal(t, len(tt.expSearch), i)
+
+		})
+	}
+}
+
+func TestTraverseBestFirst(t *testing.T) {
+
+	// the data of each node is its cost
+	tree := Empty[int]()
+	tree.Add(1, 0, 0)
+	tree.Add(2, 1, 5)
+	tree.Add(3, 1, 1)
+	tree.Add(4, 2, 6)
+	tree.Add(5, 3, 9)
+	tree.Add(6, 3, 2)
+	tree.Add(7, 6, 3)
 
+	cheapest := func(a, b Node[int]) bool { return a.GetData() < b.GetData() }
+	dearest := func(a, b Node[int]) bool { return a.GetData() > b.GetData() }
+
+	var tests = map[string]struct {
+		tree      *Tree[int]
+		less      func(a, b Node[int]) bool
+		expSearch []uint
+	}{
+		"cheapest first": {
+			tree:      tree,
+			less:      cheapest,
+			expSearch: []uint{1, 3, 6, 7, 2, 4, 5},
+		},
+		"dearest first": {
+			tree:      tree,
+			less:      dearest,
+			expSearch: []uint{1, 2, 4, 3, 5, 6, 7},
+		},
+		"empty": {
+			tree:      Empty[int](),
+			less:      cheapest,
+			expSearch: []uint{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := []uint{}
+			for n := range tt.tree.TraverseBestFirst(tt.less) {
+				got = append(got, n.GetID())
+			}
+			assert.Equal(t, tt.expSearch, got)
 		})
 	}
 }
+
+func BenchmarkTraverseBreadthFirst(b *testing.B) {
+
+	t := Empty[int]()
+	t.Add(1, 0, 1)
+	for i := uint(2); i <= 10000; i++ {
+		t.Add(i, i/4+1, int(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for range t.Traverse(TraverseBreadthFirst) {
+		}
+	}
+}
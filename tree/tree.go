@@ -19,20 +19,41 @@ in which they are added to the tree.
 
 This package includes tree traversal algorithms for breadth-first and depth-
 first search.
+
+A Tree is not safe for concurrent use. A tree shared between goroutines, where
+any of them may modify it, should be wrapped in a SyncTree.
 */
 package tree
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 )
 
+var (
+	// ErrNotFound indicates that a node with the requested primary key is not
+	// in the tree.
+	ErrNotFound = errors.New("node not found")
+	// ErrExists indicates that a node with the requested primary key is
+	// already in the tree.
+	ErrExists = errors.New("node already exists")
+	// ErrCycle indicates that an operation would create a cyclical reference
+	// between nodes.
+	ErrCycle = errors.New("operation would create a cycle")
+	// ErrIsRoot indicates that an operation cannot be performed on the root
+	// node of the tree.
+	ErrIsRoot = errors.New("node is the root of the tree")
+)
+
 // Tree is a data structure representing a tree. It contains a pointer to
 // a root node and an index of primary keys implemented as a hash map.
 type Tree[T any] struct {
-	root    Node[T]
-	primary *index[T]
+	root      Node[T]
+	primary   *index[T]
+	observers *observers[T]
+	history   *history
+	paths     *pathIndex[T]
 }
 
 // Empty creates and returns an empty tree. The empty tree has a nil pointer
@@ -73,7 +94,8 @@ func (t *Tree[T]) Root() Node[T] {
 // case where a node has no parent.
 func (t *Tree[T]) Add(nodeID uint, parentID uint, data T) (added bool, exists bool) {
 
-	child := &node[T]{primary: nodeID, parentID: parentID, data: data}
+	child := &node[T]{primary: nodeID, parentID: parentID, data: data, owner: t}
+	event := NodeAdded
 
 	// Return false if this element has already been added
 	if t.primary.find(nodeID) != nil {
@@ -89,6 +111,7 @@ func (t *Tree[T]) Add(nodeID uint, parentID uint, data T) (added bool, exists bo
 		if parent == nil {
 			if t.root.GetParentID() == nodeID { // parent does not exist but incoming node is parent of root
 				t.reroot(child)
+				event = Rerooted
 			} else { // parent does not exist, do not add
 				return
 			}
@@ -105,6 +128,16 @@ func (t *Tree[T]) Add(nodeID uint, parentID uint, data T) (added bool, exists bo
 	// add to primary index
 	t.primary.insert(nodeID, child)
 
+	if event == Rerooted {
+		oldRoot := child.children[0]
+		t.record(func() { t.unadd(child, oldRoot) }, func() { t.rerootAgain(child) })
+	} else {
+		parent := child.parent
+		t.record(func() { t.detach(nodeID) }, func() { t.attach(child, parent, -1) })
+	}
+
+	t.emit(Event[T]{Type: event, Node: child})
+
 	added = true
 	return
 }
@@ -115,6 +148,144 @@ func (t *Tree[T]) reroot(newHead Node[T]) {
 	t.root = newHead
 }
 
+// rerootAgain repeats the addition of a node above the root of the tree,
+// after it was reversed by unadd.
+func (t *Tree[T]) rerootAgain(newHead Node[T]) {
+	t.reroot(newHead)
+	t.primary.insert(newHead.GetID(), newHead)
+	t.emit(Event[T]{Type: Rerooted, Node: newHead})
+}
+
+// detach removes the node with the given primary key, along with all of its
+// descendents, from the tree. The removed subtree keeps its internal pointers
+// so that it can be re-attached later with attach. The position of the node
+// within its parent's children is returned; if the node was the root of the
+// tree, parent is nil and the tree is left empty.
+func (t *Tree[T]) detach(id uint) (n Node[T], parent Node[T], pos int, ok bool) {
+
+	n = t.primary.find(id)
+	if n == nil {
+		return
+	}
+
+	parent = n.GetParent()
+	if parent == nil {
+		t.root = nil
+	} else {
+		pos = removeChild(parent, n)
+	}
+
+	walk(n, func(d Node[T]) {
+		t.primary.remove(d.GetID())
+	})
+
+	t.record(func() { t.attach(n, parent, pos) }, func() { t.detach(id) })
+	t.emit(Event[T]{Type: Removed, Node: n, OldParentID: n.GetParentID()})
+
+	return n, parent, pos, true
+}
+
+// attach inserts a subtree, previously removed with detach, as the child of
+// parent at position pos among its children. If parent is nil, the subtree
+// becomes the root of the tree. All nodes of the subtree are added back to
+// the tree index.
+func (t *Tree[T]) attach(n Node[T], parent Node[T], pos int) {
+
+	if parent == nil {
+		t.root = n
+	} else {
+		insertChild(parent, n, pos)
+	}
+
+	walk(n, func(d Node[T]) {
+		t.primary.insert(d.GetID(), d)
+	})
+
+	t.emit(Event[T]{Type: NodeAdded, Node: n})
+}
+
+// move changes the parent of the node with the given primary key. The root of
+// the tree cannot be moved, nor can a node be moved beneath one of its own
+// descendents. The former parent and position of the node are returned so
+// that the move can be reversed.
+func (t *Tree[T]) move(id uint, parentID uint) (oldParent Node[T], pos int, err error) {
+
+	n := t.primary.find(id)
+	if n == nil {
+		return nil, 0, fmt.Errorf("node %d: %w", id, ErrNotFound)
+	}
+	if n.GetParent() == nil {
+		return nil, 0, fmt.Errorf("node %d: %w", id, ErrIsRoot)
+	}
+
+	parent := t.primary.find(parentID)
+	if parent == nil {
+		return nil, 0, fmt.Errorf("parent %d: %w", parentID, ErrNotFound)
+	}
+	for p := parent; p != nil; p = p.GetParent() {
+		if p.GetID() == id {
+			return nil, 0, fmt.Errorf("node %d beneath %d: %w", id, parentID, ErrCycle)
+		}
+	}
+
+	oldParent = n.GetParent()
+	pos = t.relink(n, parent, -1)
+
+	return oldParent, pos, nil
+}
+
+// relink moves a node from its current parent to position pos among the
+// children of parent, and returns its position among the children of its
+// former parent.
+func (t *Tree[T]) relink(n Node[T], parent Node[T], pos int) (oldPos int) {
+	oldParent, oldParentID := n.GetParent(), n.GetParentID()
+	oldPos = removeChild(oldParent, n)
+	insertChild(parent, n, pos)
+	t.record(func() { t.relink(n, oldParent, oldPos) }, func() { t.relink(n, parent, pos) })
+	t.emit(Event[T]{Type: Moved, Node: n, OldParentID: oldParentID})
+	return oldPos
+}
+
+// removeChild removes child from the children of parent and returns the
+// position at which it was found, or -1 if it was not a child of parent.
+func removeChild[T any](parent Node[T], child Node[T]) int {
+	children := parent.GetChildren()
+	for i, c := range children {
+		if c == child {
+			rest := make([]Node[T], 0, len(children)-1)
+			rest = append(rest, children[:i]...)
+			rest = append(rest, children[i+1:]...)
+			parent.ReplaceChildren(rest...)
+			return i
+		}
+	}
+	return -1
+}
+
+// insertChild inserts child among the children of parent at position pos. If
+// pos is out of range, the child is appended.
+func insertChild[T any](parent Node[T], child Node[T], pos int) {
+	children := parent.GetChildren()
+	if pos < 0 || pos >= len(children) {
+		parent.AddChildren(child)
+	} else {
+		next := make([]Node[T], 0, len(children)+1)
+		next = append(next, children[:pos]...)
+		next = append(next, child)
+		next = append(next, children[pos:]...)
+		parent.ReplaceChildren(next...)
+	}
+	child.setParent(parent)
+}
+
+// walk calls f on n and every descendent of n, depth first.
+func walk[T any](n Node[T], f func(Node[T])) {
+	f(n)
+	for _, c := range n.GetChildren() {
+		walk(c, f)
+	}
+}
+
 // Merge another tree (passed in the argument) into the target tree (passed as the
 // subject of this method call). All data from the other tree is added to the target
 // tree, if a relationship can be found between the two trees. A relationship is
@@ -125,6 +296,10 @@ func (t *Tree[T]) reroot(newHead Node[T]) {
 // fail if there are duplicate primary keys between the two trees. The merge
 // can also fail if the parent of the head of the other tree is not found in the
 // target tree.
+//
+// The nodes of the other tree are linked into the target tree, not copied, so
+// the two trees share those nodes after a successful merge. To keep the other
+// tree independent, merge a copy made with Clone.
 func (t *Tree[T]) Merge(other *Tree[T]) bool {
 
 	if other == nil {
@@ -143,13 +318,9 @@ func (t *Tree[T]) Merge(other *Tree[T]) bool {
 			}
 		}
 
-		f.AddChildren(other.root)
-		other.root.setParent(f)
-
-		// copy other index to new tree
-		for k, n := range *other.primary {
-			t.primary.insert(k, n)
-		}
+		head := other.root
+		t.remerge(head, f)
+		t.record(func() { t.unmerge(head, other) }, func() { t.remerge(head, f) })
 		return true
 	}
 
@@ -157,6 +328,36 @@ func (t *Tree[T]) Merge(other *Tree[T]) bool {
 
 }
 
+// remerge links the head of a merged tree beneath parent and adds all of its
+// nodes to the index of the tree.
+func (t *Tree[T]) remerge(head Node[T], parent Node[T]) {
+
+	parent.AddChildren(head)
+	head.setParent(parent)
+
+	// copy other index to new tree
+	walk(head, func(n Node[T]) {
+		t.primary.insert(n.GetID(), n)
+		if n, ok := n.(*node[T]); ok {
+			n.owner = t
+		}
+	})
+
+	t.emit(Event[T]{Type: Merged, Node: head})
+}
+
+// unmerge reverses the merge of another tree with the given head, returning
+// its nodes to the other tree.
+func (t *Tree[T]) unmerge(head Node[T], other *Tree[T]) {
+	t.detach(head.GetID())
+	head.unsetParent()
+	walk(head, func(n Node[T]) {
+		if n, ok := n.(*node[T]); ok {
+			n.owner = other
+		}
+	})
+}
+
 // Find looks up a node by its primary key. If the node is found, then
 // ok is true and a Node is returned. If the node is not found, then
 // ok is false an a nil pointer is returned.
@@ -209,37 +410,42 @@ type serialNode[T any] struct {
 // The associated data of each node is serialized with it. This data may be
 // set the the caller and may not be serializable. If the associated data
 // cannot be serialzied using the json package, then this function will
-// throw an error. Once any error is thrown, serialization stops.
+// throw an error. Once any error is thrown, serialization stops, and reading
+// from the ReadCloser returns the error.
 //
 // The serialization is implemented into a goroutine which will populate the
 // ReadCloser return value as elements are consumed from it by the caller.
 // the <-chan error exists to pass any serialization error back from the
 // encoding goroutine.
+//
+// The stream has no header, so that it stays readable by code written before
+// headers were introduced. It is read back as schema version zero; a tree
+// whose data type has a schema registered with RegisterSchema should be
+// written with SerializeWith instead, or its data is migrated again when read.
 func (t *Tree[T]) Serialize(trvsl TraversalType) (io.ReadCloser, <-chan error) {
-	reader, writer := io.Pipe()
-	errchan := make(chan error)
-
-	go func() {
-		encoder := json.NewEncoder(writer)
-		for n := range t.Traverse(trvsl) {
-			err := encoder.Encode(serialNode[T]{
-				Primary:  n.GetID(),
-				ParentID: n.GetParentID(),
-				Data:     n.GetData(),
-			})
-			if err != nil {
-				errchan <- err
-				writer.Close()
-				return
-			}
+	return t.serialize(trvsl, SerializeOptions{}, false)
+}
 
-		}
+// serializeTo writes the tree to w in the format of SerializeWith with no
+// options, and returns the first error encoding the tree or writing to w. The
+// header records the schema version of the data, so that it is not migrated
+// again when read back.
+func (t *Tree[T]) serializeTo(w io.Writer, trvsl TraversalType) error {
 
-		close(errchan)
-		writer.Close()
-	}()
+	rdr, errchan := t.SerializeWith(trvsl, SerializeOptions{})
 
-	return reader, errchan
+	// the encoding goroutine reports an error before closing the stream, so
+	// its error must be received while the stream is read
+	encodeErr := make(chan error, 1)
+	go func() { encodeErr <- <-errchan }()
+
+	_, copyErr := io.Copy(w, rdr)
+	rdr.Close()
+
+	if err := <-encodeErr; err != nil {
+		return err
+	}
+	return copyErr
 }
 
 // Deserialize decodes a data stream into a tree.
@@ -251,15 +457,33 @@ func (t *Tree[T]) Serialize(trvsl TraversalType) (io.ReadCloser, <-chan error) {
 // The argument ReadCloser is a stream with data from a serialized tree. If any
 // node of the tree fails to deserialize, this function will abord and return an
 // error.
+//
+// Streams written by SerializeWith are decompressed and their trailer is
+// verified. If such a stream ends before its trailer, the error wraps
+// ErrTruncated; if it does not match its trailer, the error wraps ErrCorrupt.
+// The data of nodes written with an older schema version than the one
+// registered for T is migrated; see RegisterSchema.
 func Deserialize[T any](stream io.ReadCloser) (*Tree[T], error) {
-	decoder := json.NewDecoder(stream)
+	return deserialize[T](stream, false)
+}
+
+// deserialize decodes a stream like Deserialize. If current is set, a stream
+// without a header is taken to be at the schema version registered for T,
+// rather than zero, as are the streams written by this package for its own
+// use, and its data is not migrated.
+func deserialize[T any](stream io.Reader, current bool) (*Tree[T], error) {
+	frames, err := newFrameReader[T](stream)
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing: %w", err)
+	}
+	if current {
+		frames.headerless = frames.schema
+	}
 	t := Empty[T]()
 
 	for {
 
-		var n serialNode[T]
-
-		err := decoder.Decode(&n)
+		n, err := frames.next()
 		if err == io.EOF {
 			return t, nil
 		}
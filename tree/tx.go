@@ -0,0 +1,139 @@
+package tree
+
+import "fmt"
+
+// Tx is a transaction on a tree, created by Tree.Batch. Mutations made
+// through a transaction are applied to the tree as they are made, so that
+// reads through the transaction see them, but they are reversed if the
+// transaction fails.
+//
+// A Tx must only be used inside the function passed to Batch.
+type Tx[T any] struct {
+	tree *Tree[T]
+	undo []func()
+}
+
+// Batch runs f as a transaction on the tree. If f returns nil, every mutation
+// made through the transaction is kept and Batch returns nil. If f returns an
+// error, every mutation is reversed, restoring the parent pointers, children
+// and index of the tree to their state before Batch was called, and the error
+// is returned. If f panics, the mutations are reversed before the panic
+// continues.
+//
+// Events for the mutations of a transaction are delivered only once f has
+// returned nil; a failed transaction emits no events.
+//
+// Only mutations made through the transaction are reversed. f must not modify
+// the tree other than through tx, including through the Node interface.
+func (t *Tree[T]) Batch(f func(tx *Tx[T]) error) (err error) {
+
+	tx := &Tx[T]{tree: t}
+	release := t.hold()
+	end := t.group()
+
+	committed := false
+	defer func() {
+		if !committed {
+			rollback(tx.undo)
+			end(false)
+			release(false)
+		}
+	}()
+
+	if err = f(tx); err != nil {
+		return err
+	}
+
+	committed = true
+	end(true)
+	release(true)
+	return nil
+}
+
+// Add inserts an element into the tree as a node, following the rules of
+// Tree.Add. An error is returned if the element could not be inserted; see
+// Tree.Apply for the errors of each operation.
+func (tx *Tx[T]) Add(nodeID uint, parentID uint, data T) error {
+	return tx.apply(Op[T]{Type: OpAdd, ID: nodeID, ParentID: parentID, Data: data})
+}
+
+// Remove removes the node with the given primary key, along with all of its
+// descendents, from the tree.
+func (tx *Tx[T]) Remove(id uint) error {
+	return tx.apply(Op[T]{Type: OpRemove, ID: id})
+}
+
+// Move makes the node with the given primary key, along with its
+// descendents, a child of the node with primary key parentID.
+func (tx *Tx[T]) Move(id uint, parentID uint) error {
+	return tx.apply(Op[T]{Type: OpMove, ID: id, ParentID: parentID})
+}
+
+// SetData replaces the data of the node with the given primary key.
+func (tx *Tx[T]) SetData(id uint, data T) error {
+	return tx.apply(Op[T]{Type: OpSetData, ID: id, Data: data})
+}
+
+// Apply performs every operation of a patch on the tree. The patch itself is
+// applied atomically, as by Tree.Apply; if it fails, the transaction may
+// continue with the tree as it was before the patch.
+func (tx *Tx[T]) Apply(p Patch[T]) error {
+	undo, err := tx.tree.applyPatch(p)
+	if err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, undo...)
+	return nil
+}
+
+// Merge another tree into the tree, following the rules of Tree.Merge. If
+// the parent of the head of the other tree is not found, ErrNotFound is
+// returned; if the trees share a primary key, ErrExists is returned.
+//
+// If the transaction fails after a successful merge, the other tree is
+// restored as well.
+func (tx *Tx[T]) Merge(other *Tree[T]) error {
+
+	t := tx.tree
+
+	if other == nil || other.root == nil {
+		return fmt.Errorf("merge: %w", ErrNotFound)
+	}
+	headParent := other.root.GetParentID()
+	if t.primary.find(headParent) == nil {
+		return fmt.Errorf("merge beneath %d: %w", headParent, ErrNotFound)
+	}
+	if !t.Merge(other) {
+		return fmt.Errorf("merge beneath %d: %w", headParent, ErrExists)
+	}
+
+	head := other.root
+	tx.undo = append(tx.undo, func() { t.unmerge(head, other) })
+	return nil
+}
+
+// Root returns the root node of the tree, including pending changes.
+func (tx *Tx[T]) Root() Node[T] {
+	return tx.tree.Root()
+}
+
+// Find looks up a node by its primary key, including pending changes. See
+// Tree.Find.
+func (tx *Tx[T]) Find(id uint) (n Node[T], ok bool) {
+	return tx.tree.Find(id)
+}
+
+// FindParents finds the list of all parent nodes between a target node and the
+// root of the tree, including pending changes. See Tree.FindParents.
+func (tx *Tx[T]) FindParents(id uint) (parents []Node[T], ok bool) {
+	return tx.tree.FindParents(id)
+}
+
+func (tx *Tx[T]) apply(op Op[T]) error {
+	undo, err := tx.tree.apply(op)
+	if err != nil {
+		return fmt.Errorf("%s %d: %w", op.Type, op.ID, err)
+	}
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
@@ -0,0 +1,228 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+
+	errAbort := errors.New("abort")
+
+	var tests = map[string]struct {
+		batch   func(tx *Tx[string]) error
+		expErr  error
+		expBFC  []uint
+		expDFC  []uint
+		expData map[uint]string
+	}{
+		"commit": {
+			batch: func(tx *Tx[string]) error {
+				if err := tx.Add(6, 5, "six"); err != nil {
+					return err
+				}
+				if err := tx.Move(3, 6); err != nil {
+					return err
+				}
+				if err := tx.Remove(4); err != nil {
+					return err
+				}
+				return tx.SetData(2, "TWO")
+			},
+			expBFC:  []uint{1, 2, 5, 6, 3},
+			expDFC:  []uint{1, 2, 5, 6, 3},
+			expData: map[uint]string{2: "TWO"},
+		},
+		"failed operation": {
+			batch: func(tx *Tx[string]) error {
+				if err := tx.Add(6, 5, "six"); err != nil {
+					return err
+				}
+				if err := tx.SetData(1, "ONE"); err != nil {
+					return err
+				}
+				return tx.Move(1, 6)
+			},
+			expErr:  ErrIsRoot,
+			expBFC:  []uint{1, 2, 5, 3, 4},
+			expDFC:  []uint{1, 2, 3, 4, 5},
+			expData: map[uint]string{1: "one"},
+		},
+		"caller error": {
+			batch: func(tx *Tx[string]) error {
+				tx.Remove(2)
+				tx.Add(6, 1, "six")
+				tx.Add(2, 6, "two")
+				return errAbort
+			},
+			expErr:  errAbort,
+			expBFC:  []uint{1, 2, 5, 3, 4},
+			expDFC:  []uint{1, 2, 3, 4, 5},
+			expData: map[uint]string{2: "two"},
+		},
+		"ignored failure": {
+			batch: func(tx *Tx[string]) error {
+				tx.Add(6, 9, "six")
+				return tx.Add(7, 5, "seven")
+			},
+			expBFC: []uint{1, 2, 5, 3, 4, 7},
+			expDFC: []uint{1, 2, 3, 4, 5, 7},
+		},
+		"reads see pending writes": {
+			batch: func(tx *Tx[string]) error {
+				tx.Add(6, 5, "six")
+				tx.Move(2, 6)
+				if _, ok := tx.Find(6); !ok {
+					return errAbort
+				}
+				parents, _ := tx.FindParents(3)
+				if len(parents) != 4 || tx.Root().GetID() != 1 {
+					return errAbort
+				}
+				return nil
+			},
+			expBFC: []uint{1, 5, 6, 2, 3, 4},
+			expDFC: []uint{1, 5, 6, 2, 3, 4},
+		},
+		"patch rolled back": {
+			batch: func(tx *Tx[string]) error {
+				err := tx.Apply(Patch[string]{
+					{Type: OpAdd, ID: 6, ParentID: 5},
+					{Type: OpRemove, ID: 2},
+				})
+				if err != nil {
+					return err
+				}
+				return errAbort
+			},
+			expErr: errAbort,
+			expBFC: []uint{1, 2, 5, 3, 4},
+			expDFC: []uint{1, 2, 3, 4, 5},
+		},
+		"failed patch continues": {
+			batch: func(tx *Tx[string]) error {
+				tx.Apply(Patch[string]{
+					{Type: OpAdd, ID: 6, ParentID: 5},
+					{Type: OpRemove, ID: 9},
+				})
+				return tx.Add(6, 4, "six")
+			},
+			expBFC: []uint{1, 2, 5, 3, 4, 6},
+			expDFC: []uint{1, 2, 3, 4, 6, 5},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := patchTestTree()
+			gotErr := tree.Batch(tt.batch)
+
+			if tt.expErr != nil {
+				assert.ErrorIs(t, gotErr, tt.expErr)
+			} else {
+				assert.NoError(t, gotErr)
+			}
+
+			assert.Equal(t, tt.expBFC, bfc([]Node[string]{tree.root}, []uint{}))
+			assert.Equal(t, tt.expDFC, dfc(tree.root, []uint{}))
+			assert.Len(t, *tree.primary, len(tt.expBFC))
+			for _, key := range tt.expBFC {
+				k := tree.primary.find(key)
+				if assert.NotNil(t, k, "Expected value for %d not to be nil", key) {
+					assert.Equal(t, key, k.GetID())
+					if p := k.GetParent(); p != nil {
+						assert.Equal(t, p.GetID(), k.GetParentID())
+					}
+				}
+			}
+			for id, data := range tt.expData {
+				n, _ := tree.Find(id)
+				assert.Equal(t, data, n.GetData())
+			}
+		})
+	}
+}
+
+func TestBatchMerge(t *testing.T) {
+
+	tree := patchTestTree()
+	other := Empty[string]()
+	other.Add(6, 5, "six")
+	other.Add(7, 6, "seven")
+
+	err := tree.Batch(func(tx *Tx[string]) error {
+		assert.ErrorIs(t, tx.Merge(Empty[string]()), ErrNotFound)
+		if err := tx.Merge(other); err != nil {
+			return err
+		}
+		assert.ErrorIs(t, tx.Merge(other), ErrExists)
+		if _, ok := tx.Find(7); !ok {
+			t.Error("merged node not found in transaction")
+		}
+		return errors.New("abort")
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, []uint{1, 2, 5, 3, 4}, bfc([]Node[string]{tree.root}, []uint{}))
+	assert.Len(t, *tree.primary, 5)
+	assert.Nil(t, other.root.GetParent())
+
+	// the other tree is independent again
+	got := []EventType{}
+	other.Subscribe(func(e Event[string]) { got = append(got, e.Type) })
+	n, _ := other.Find(7)
+	n.SetData("SEVEN")
+	assert.Equal(t, []EventType{DataChanged}, got)
+}
+
+func TestBatchPanic(t *testing.T) {
+
+	tree := patchTestTree()
+
+	assert.Panics(t, func() {
+		tree.Batch(func(tx *Tx[string]) error {
+			tx.Remove(2)
+			panic("boom")
+		})
+	})
+
+	assert.Equal(t, []uint{1, 2, 5, 3, 4}, bfc([]Node[string]{tree.root}, []uint{}))
+}
+
+func TestBatchEvents(t *testing.T) {
+
+	tree := patchTestTree()
+	got := []EventType{}
+	tree.Subscribe(func(e Event[string]) { got = append(got, e.Type) })
+
+	tree.Batch(func(tx *Tx[string]) error {
+		tx.Add(6, 5, "six")
+		return errors.New("abort")
+	})
+	assert.Empty(t, got)
+
+	tree.Batch(func(tx *Tx[string]) error {
+		tx.Apply(Patch[string]{
+			{Type: OpAdd, ID: 6, ParentID: 5},
+			{Type: OpRemove, ID: 9},
+		})
+		tx.Add(7, 5, "seven")
+		assert.Empty(t, got)
+		return tx.Move(7, 4)
+	})
+	assert.Equal(t, []EventType{NodeAdded, Moved}, got)
+}
+
+func TestSyncTreeBatch(t *testing.T) {
+
+	s := NewSync(patchTestTree())
+	err := s.Batch(func(tx *Tx[string]) error {
+		return tx.Add(6, 5, "six")
+	})
+
+	assert.NoError(t, err)
+	_, ok := s.Find(6)
+	assert.True(t, ok)
+}
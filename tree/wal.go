@@ -0,0 +1,377 @@
+package tree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogOptions configures a LogStore.
+type LogOptions struct {
+	// CompactEvery is the number of records appended to the log after which
+	// the log is compacted into a snapshot. If zero or less, the log is only
+	// compacted by calling Compact.
+	CompactEvery int
+	// Sync flushes the log to stable storage after every record. Without it,
+	// records written shortly before a crash of the machine, rather than of
+	// the process, may be lost.
+	Sync bool
+}
+
+// LogStore keeps a tree in a directory as a snapshot and an append-only log
+// of the mutations made since the snapshot was written. Every mutation of the
+// tree is appended to the log as it happens, so that the cost of persisting a
+// change does not depend on the size of the tree. When the store is opened,
+// the snapshot is read and the log is replayed on top of it.
+//
+// The snapshot is written in the format of Tree.SerializeWith, whose header
+// records the schema version of the data. Each record of the log is one
+// operation of a Patch, encoded as by Patch.Serialize. Compaction writes a new
+// snapshot of the whole tree and starts a new, empty log. Every
+// snapshot and its log carry a generation number in their file names; a
+// generation replaces the one before it only once its snapshot is complete, so
+// that a crash during compaction leaves the previous generation usable.
+//
+// If a crash interrupts the writing of a record, the log ends with a partial
+// record. The partial record is discarded when the store is opened, and the
+// log is truncated to the last complete record.
+//
+// Mutations are captured through the events of the tree, so changes made
+// through the Node interface are persisted as well, but the tree must not be
+// modified from event subscribers. A patch or transaction that fails is
+// logged along with the changes that reverse it. Errors writing to the log
+// cannot be returned from the mutation that caused them; the first such error
+// stops all further writes and is reported by Err and Close.
+type LogStore[T any] struct {
+	dir  string
+	opts LogOptions
+	tree *Tree[T]
+
+	gen     int
+	log     *os.File
+	records int
+	err     error
+	cancel  func()
+}
+
+const (
+	logSnapshotPrefix = "snapshot-"
+	logSnapshotSuffix = ".jsonl"
+	logFilePrefix     = "log-"
+	logFileSuffix     = ".jsonl"
+)
+
+// OpenLogStore opens the store in directory dir, creating the directory if it
+// does not exist, and returns it with its tree restored from the latest
+// snapshot and log.
+func OpenLogStore[T any](dir string, opts LogOptions) (*LogStore[T], error) {
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error opening log store: %w", err)
+	}
+
+	gen, err := latestGeneration(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log store: %w", err)
+	}
+
+	s := &LogStore[T]{dir: dir, opts: opts, gen: gen}
+
+	if s.tree, err = s.readSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err = s.replay(); err != nil {
+		return nil, err
+	}
+
+	s.removeOldGenerations()
+	s.cancel = s.tree.watch(s.write)
+
+	return s, nil
+}
+
+// Tree returns the tree kept by the store. Every mutation of the tree is
+// persisted until the store is closed.
+func (s *LogStore[T]) Tree() *Tree[T] {
+	return s.tree
+}
+
+// Err returns the first error that occurred writing to the log, if any.
+func (s *LogStore[T]) Err() error {
+	return s.err
+}
+
+// Close stops persisting mutations of the tree and closes the log. It returns
+// the first error that occurred writing to the log, if any.
+func (s *LogStore[T]) Close() error {
+	s.cancel()
+	if err := s.log.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// Compact writes a snapshot of the whole tree and starts a new, empty log.
+func (s *LogStore[T]) Compact() error {
+
+	if s.err != nil {
+		return s.err
+	}
+
+	next := s.gen + 1
+
+	// the log is created first: once the snapshot is in place, the store is
+	// opened at the next generation, and must find its log there. A log
+	// without a snapshot is never read.
+	log, err := os.OpenFile(s.logPath(next), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error compacting log store: %w", err)
+	}
+
+	if err := s.writeSnapshot(next); err != nil {
+		log.Close()
+		os.Remove(s.logPath(next))
+		return fmt.Errorf("error compacting log store: %w", err)
+	}
+
+	s.log.Close()
+	s.log = log
+	s.gen = next
+	s.records = 0
+
+	s.removeOldGenerations()
+	return nil
+}
+
+// write appends the operations for an event of the tree to the log.
+func (s *LogStore[T]) write(e Event[T]) {
+
+	if s.err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, op := range s.ops(e) {
+		if err := encoder.Encode(op); err != nil {
+			s.err = fmt.Errorf("error encoding log record: %w", err)
+			return
+		}
+		s.records++
+	}
+	if buf.Len() == 0 {
+		return
+	}
+
+	if _, err := s.log.Write(buf.Bytes()); err != nil {
+		s.err = fmt.Errorf("error writing log record: %w", err)
+		return
+	}
+	if s.opts.Sync {
+		if err := s.log.Sync(); err != nil {
+			s.err = fmt.Errorf("error syncing log: %w", err)
+			return
+		}
+	}
+
+	if s.opts.CompactEvery > 0 && s.records >= s.opts.CompactEvery {
+		if err := s.Compact(); err != nil {
+			s.err = err
+		}
+	}
+}
+
+// ops translates an event of the tree into the operations that reproduce it
+// when replayed.
+func (s *LogStore[T]) ops(e Event[T]) []Op[T] {
+
+	n := e.Node
+
+	switch e.Type {
+	case NodeAdded, Merged: // a node or a whole subtree was added
+		ops := []Op[T]{}
+		walk(n, func(d Node[T]) {
+			ops = append(ops, Op[T]{Type: OpAdd, ID: d.GetID(), ParentID: d.GetParentID(), Data: d.GetData()})
+		})
+		return ops
+	case Rerooted:
+		return []Op[T]{{Type: OpAdd, ID: n.GetID(), ParentID: n.GetParentID(), Data: n.GetData()}}
+	case DataChanged:
+		if f := s.tree.primary.find(n.GetID()); f != n { // not a node of the tree
+			return nil
+		}
+		return []Op[T]{{Type: OpSetData, ID: n.GetID(), Data: n.GetData()}}
+	case Removed:
+		return []Op[T]{{Type: OpRemove, ID: n.GetID()}}
+	case Moved:
+		return []Op[T]{{Type: OpMove, ID: n.GetID(), ParentID: n.GetParentID()}}
+	}
+
+	return nil
+}
+
+// replay applies the records of the current log to the tree, and leaves the
+// log open for appending. A partial record at the end of the log is removed.
+func (s *LogStore[T]) replay() error {
+
+	log, err := os.OpenFile(s.logPath(s.gen), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening log: %w", err)
+	}
+
+	reader := bufio.NewReader(log)
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without its newline is a partial record
+			break
+		}
+		if err != nil {
+			log.Close()
+			return fmt.Errorf("error reading log: %w", err)
+		}
+
+		var op Op[T]
+		if err := json.Unmarshal(line, &op); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				break // the last record is incomplete
+			}
+			log.Close()
+			return fmt.Errorf("error decoding log record at offset %d: %w", offset, err)
+		}
+
+		if _, err := s.tree.apply(op); err != nil {
+			log.Close()
+			return fmt.Errorf("error replaying log record at offset %d (%s %d): %w", offset, op.Type, op.ID, err)
+		}
+
+		offset += int64(len(line))
+		s.records++
+	}
+
+	if err := log.Truncate(offset); err != nil {
+		log.Close()
+		return fmt.Errorf("error truncating log: %w", err)
+	}
+	if _, err := log.Seek(offset, io.SeekStart); err != nil {
+		log.Close()
+		return fmt.Errorf("error opening log: %w", err)
+	}
+
+	s.log = log
+	return nil
+}
+
+func (s *LogStore[T]) readSnapshot() (*Tree[T], error) {
+
+	if s.gen == 0 {
+		return Empty[T](), nil
+	}
+
+	f, err := os.Open(s.snapshotPath(s.gen))
+	if err != nil {
+		return nil, fmt.Errorf("error opening snapshot: %w", err)
+	}
+	defer f.Close()
+
+	return Deserialize[T](f)
+}
+
+// writeSnapshot writes the whole tree as the snapshot of a generation. The
+// snapshot is written to a temporary file that is renamed once complete.
+func (s *LogStore[T]) writeSnapshot(gen int) error {
+
+	path := s.snapshotPath(gen)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.tree.serializeTo(tmp, TraverseBreadthFirst); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeOldGenerations removes the snapshots and logs of generations before
+// the current one. Failures are ignored; old generations are never read.
+func (s *LogStore[T]) removeOldGenerations() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if gen, ok := parseGeneration(e.Name()); ok && gen < s.gen {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+func (s *LogStore[T]) snapshotPath(gen int) string {
+	return filepath.Join(s.dir, logSnapshotPrefix+strconv.Itoa(gen)+logSnapshotSuffix)
+}
+
+func (s *LogStore[T]) logPath(gen int) string {
+	return filepath.Join(s.dir, logFilePrefix+strconv.Itoa(gen)+logFileSuffix)
+}
+
+// latestGeneration finds the highest generation with a complete snapshot. A
+// directory without snapshots is at generation zero, which has an empty tree
+// as its snapshot.
+func latestGeneration(dir string) (int, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	gens := []int{0}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, logSnapshotPrefix) || !strings.HasSuffix(name, logSnapshotSuffix) {
+			continue
+		}
+		if gen, ok := parseGeneration(name); ok {
+			gens = append(gens, gen)
+		}
+	}
+
+	sort.Ints(gens)
+	return gens[len(gens)-1], nil
+}
+
+// parseGeneration extracts the generation number from the name of a snapshot
+// or log file.
+func parseGeneration(name string) (int, bool) {
+	for _, affix := range [][2]string{
+		{logSnapshotPrefix, logSnapshotSuffix},
+		{logFilePrefix, logFileSuffix},
+	} {
+		if strings.HasPrefix(name, affix[0]) && strings.HasSuffix(name, affix[1]) {
+			gen, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, affix[0]), affix[1]))
+			return gen, err == nil
+		}
+	}
+	return 0, false
+}
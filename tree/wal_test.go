@@ -0,0 +1,335 @@
+package tree
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStoreReopen(t *testing.T) {
+
+	var tests = map[string]struct {
+		opts   LogOptions
+		change func(*Tree[string])
+	}{
+		"add": {
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+				t.Add(2, 1, "two")
+				t.Add(3, 2, "three")
+			},
+		},
+		"re-root": {
+			change: func(t *Tree[string]) {
+				t.Add(1, 2, "one")
+				t.Add(3, 1, "three")
+				t.Add(2, 0, "two")
+			},
+		},
+		"merge": {
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+				other := Empty[string]()
+				other.Add(2, 1, "two")
+				other.Add(3, 2, "three")
+				t.Merge(other)
+			},
+		},
+		"set data through node": {
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+				t.Add(2, 1, "two")
+				n, _ := t.Find(2)
+				n.SetData("TWO")
+			},
+		},
+		"patch": {
+			change: func(t *Tree[string]) {
+				t.Apply(Patch[string]{
+					{Type: OpAdd, ID: 1, Data: "one"},
+					{Type: OpAdd, ID: 2, ParentID: 1, Data: "two"},
+					{Type: OpAdd, ID: 3, ParentID: 2, Data: "three"},
+					{Type: OpAdd, ID: 4, ParentID: 1, Data: "four"},
+					{Type: OpMove, ID: 3, ParentID: 4},
+					{Type: OpRemove, ID: 2},
+				})
+			},
+		},
+		"failed batch": {
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+				t.Batch(func(tx *Tx[string]) error {
+					tx.Add(2, 1, "two")
+					return tx.Remove(9)
+				})
+			},
+		},
+		"undo": {
+			change: func(t *Tree[string]) {
+				t.EnableHistory(0)
+				t.Add(1, 2, "one")
+				t.Add(3, 1, "three")
+				t.Add(2, 0, "two")
+				t.Apply(Patch[string]{{Type: OpRemove, ID: 3}})
+				t.Undo()
+				t.Undo()
+			},
+		},
+		"compaction": {
+			opts: LogOptions{CompactEvery: 2, Sync: true},
+			change: func(t *Tree[string]) {
+				t.Add(1, 0, "one")
+				t.Add(2, 1, "two")
+				t.Add(3, 2, "three")
+				t.Add(4, 2, "four")
+				t.Apply(Patch[string]{{Type: OpRemove, ID: 3}})
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			s, err := OpenLogStore[string](dir, tt.opts)
+			require.NoError(t, err)
+			tt.change(s.Tree())
+			exp := s.Tree().Clone(nil)
+			require.NoError(t, s.Close())
+
+			got, err := OpenLogStore[string](dir, tt.opts)
+			require.NoError(t, err)
+			defer got.Close()
+
+			assert.True(t, Equal(exp, got.Tree(), nil, false))
+		})
+	}
+}
+
+func TestLogStoreCompaction(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := OpenLogStore[int](dir, LogOptions{CompactEvery: 3})
+	require.NoError(t, err)
+
+	for i := uint(1); i <= 10; i++ {
+		s.Tree().Add(i, i-1, int(i))
+	}
+	exp := s.Tree().Clone(nil)
+
+	// generation 3 holds nine nodes; the tenth is in its log
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"snapshot-3.jsonl", "log-3.jsonl"}, names)
+
+	require.NoError(t, s.Compact())
+	require.NoError(t, s.Close())
+
+	got, err := OpenLogStore[int](dir, LogOptions{})
+	require.NoError(t, err)
+	defer got.Close()
+	assert.True(t, Equal(exp, got.Tree(), nil, true))
+
+	info, err := os.Stat(filepath.Join(dir, "log-4.jsonl"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestLogStoreInterruptedCompaction(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := OpenLogStore[int](dir, LogOptions{})
+	require.NoError(t, err)
+	s.Tree().Add(1, 0, 1)
+	s.Tree().Add(2, 1, 2)
+	exp := s.Tree().Clone(nil)
+	require.NoError(t, s.Close())
+
+	// a snapshot that was never renamed into place is ignored
+	err = os.WriteFile(filepath.Join(dir, "snapshot-1.jsonl.123.tmp"), []byte(`{"Primary":1`), 0o644)
+	require.NoError(t, err)
+
+	got, err := OpenLogStore[int](dir, LogOptions{})
+	require.NoError(t, err)
+	defer got.Close()
+	assert.True(t, Equal(exp, got.Tree(), nil, true))
+}
+
+func TestLogStoreTruncatedRecord(t *testing.T) {
+
+	var tests = map[string]struct {
+		tail   string
+		expErr bool
+	}{
+		"partial record": {
+			tail: `{"Type":"add","ID":9,"Par`,
+		},
+		"partial record with newline": {
+			tail: "{\"Type\":\"add\",\"ID\":9,\"Par\n",
+		},
+		"corrupt record before the last": {
+			tail:   "{\"Type\":\"add\",\"ID\":9,\"Par\n{\"Type\":\"set\",\"ID\":1,\"Data\":\"x\"}\n",
+			expErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			s, err := OpenLogStore[string](dir, LogOptions{})
+			require.NoError(t, err)
+			s.Tree().Add(1, 0, "one")
+			s.Tree().Add(2, 1, "two")
+			require.NoError(t, s.Close())
+
+			path := filepath.Join(dir, "log-0.jsonl")
+			before, err := os.ReadFile(path)
+			require.NoError(t, err)
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+			require.NoError(t, err)
+			f.WriteString(tt.tail)
+			f.Close()
+
+			got, err := OpenLogStore[string](dir, LogOptions{})
+			if tt.expErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, []uint{1, 2}, bfc([]Node[string]{got.Tree().root}, []uint{}))
+			after, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, before, after)
+
+			// the log continues after the last complete record
+			got.Tree().Add(3, 2, "three")
+			require.NoError(t, got.Close())
+
+			again, err := OpenLogStore[string](dir, LogOptions{})
+			require.NoError(t, err)
+			defer again.Close()
+			assert.Equal(t, []uint{1, 2, 3}, bfc([]Node[string]{again.Tree().root}, []uint{}))
+		})
+	}
+}
+
+func TestLogStoreWriteError(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := OpenLogStore[func()](dir, LogOptions{})
+	require.NoError(t, err)
+
+	s.Tree().Add(1, 0, func() {})
+	assert.Error(t, s.Err())
+	assert.Error(t, s.Close())
+}
+
+func TestLogStoreCompactEncodeError(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := OpenLogStore[*float64](dir, LogOptions{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the data is logged, then changed behind the store to a value that
+	// cannot be encoded
+	v := 1.0
+	s.Tree().Add(1, 0, &v)
+	require.NoError(t, s.Err())
+	v = math.Inf(1)
+
+	done := make(chan error)
+	go func() { done <- s.Compact() }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Compact did not return")
+	}
+}
+
+func TestLogStoreCompactLogError(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := OpenLogStore[string](dir, LogOptions{})
+	require.NoError(t, err)
+	s.Tree().Add(1, 0, "a")
+
+	// the log of the next generation cannot be created
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "log-1.jsonl"), 0o755))
+	assert.Error(t, s.Compact())
+	require.NoError(t, os.Remove(filepath.Join(dir, "log-1.jsonl")))
+
+	// mutations after the failed compaction are still persisted
+	s.Tree().Add(2, 1, "b")
+	require.NoError(t, s.Close())
+
+	again, err := OpenLogStore[string](dir, LogOptions{})
+	require.NoError(t, err)
+	defer again.Close()
+	assert.Equal(t, []uint{1, 2}, bfc([]Node[string]{again.Tree().root}, []uint{}))
+}
+
+func TestLogStoreCompactDuringUndoReroot(t *testing.T) {
+
+	// undoing a root replacement emits the removal of the new root, then the
+	// addition of the former one; compaction may fall between the two
+	var tests = map[string]struct {
+		compactEvery int
+		setup        func(tree *Tree[string])
+	}{
+		"every event": {
+			compactEvery: 1,
+			setup: func(tree *Tree[string]) {
+				tree.Add(1, 9, "one")
+				tree.Add(2, 1, "two")
+			},
+		},
+		"on the removal only": {
+			compactEvery: 3,
+			setup: func(tree *Tree[string]) {
+				tree.Add(1, 9, "one")
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			s, err := OpenLogStore[string](dir, LogOptions{CompactEvery: tt.compactEvery})
+			require.NoError(t, err)
+			s.Tree().EnableHistory(10)
+			tt.setup(s.Tree())
+			exp := bfc([]Node[string]{s.Tree().root}, []uint{})
+			s.Tree().Add(9, 0, "nine")
+			require.Equal(t, uint(9), s.Tree().Root().GetID())
+
+			require.True(t, s.Tree().Undo())
+			require.NoError(t, s.Err())
+			require.NoError(t, s.Close())
+
+			again, err := OpenLogStore[string](dir, LogOptions{})
+			require.NoError(t, err)
+			defer again.Close()
+			assert.Equal(t, exp, bfc([]Node[string]{again.Tree().root}, []uint{}))
+			assert.Equal(t, uint(9), again.Tree().Root().GetParentID())
+		})
+	}
+}